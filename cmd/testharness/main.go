@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -21,13 +23,47 @@ import (
 func main() {
 	resource := flag.String("resource", "", "The mutex's resource")
 	loopForever := flag.Bool("loop", false, "Loop forever after locking the mutex")
+	readLock := flag.Bool("rlock", false, "Lock an RWMutex for reading instead of locking a Mutex")
+	label := flag.String("label", "", "The label to record when locking the mutex")
+	backendName := flag.String("backend", ipcm.DefaultBackend.String(), "The mutex backend to use")
+	leaseTTL := flag.Duration("lease", 0, "Lock the mutex as a lease with the specified TTL")
+	fencingTokens := flag.Bool("tokens", false, "Enable fencing tokens")
+	fair := flag.Bool("fair", false, "Grant the mutex in the order in which processes wait for it")
+	priorityAging := flag.Duration("aging", 0, "The priority aging of a fair mutex")
+	priority := flag.Int("priority", 0, "The priority to lock the mutex with")
 	ipcTestPath := flag.String("ipcfile", "", "A file for testing IPC")
 	ipcValue := flag.Int("ipcvalue", 0, "The number of times to increment the IPC value by")
+	orderFilePath := flag.String("orderfile", "", "A file to record the order in which processes lock the mutex to")
+	iterations := flag.Int("iterations", 0, "The number of times to lock the mutex when recording the lock order")
+	hold := flag.Duration("hold", 20*time.Millisecond, "How long to hold the mutex when recording the lock order")
+	deadlockRegistry := flag.String("registry", "", "The deadlock registry directory")
+	nextResource := flag.String("next", "", "A second mutex resource to lock while holding the mutex")
 
 	flag.Parse()
 
+	backend, err := parseBackend(*backendName)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+
+	if *readLock {
+		err := doReadLock(*resource, *loopForever)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		return
+	}
+
 	m, err := ipcm.NewMutex(ipcm.MutexConfig{
-		Resource: *resource,
+		Resource:         *resource,
+		Label:            *label,
+		Backend:          backend,
+		LeaseTTL:         *leaseTTL,
+		FencingTokens:    *fencingTokens,
+		Fair:             *fair,
+		PriorityAging:    *priorityAging,
+		DeadlockRegistry: *deadlockRegistry,
 	})
 	if err != nil {
 		log.Fatalln(err.Error())
@@ -42,6 +78,16 @@ func main() {
 		return
 	}
 
+	if len(*orderFilePath) > 0 {
+		ctx := ipcm.WithPriority(context.Background(), *priority)
+		err := doLockOrderTest(ctx, m, *orderFilePath, *iterations, *hold)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+
+		return
+	}
+
 	err = m.TimedTryLock(1 * time.Second)
 	if err != nil {
 		log.Fatalln(err.Error())
@@ -50,10 +96,89 @@ func main() {
 
 	if *loopForever {
 		fmt.Println("ready")
+
+		if len(*nextResource) > 0 {
+			err := lockNext(*nextResource, backend, *deadlockRegistry)
+			if err != nil {
+				log.Fatalln(err.Error())
+			}
+		}
+
+		for {
+			select {
+			case <-m.Lost():
+				fmt.Println("lost")
+				return
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}
+}
+
+func parseBackend(name string) (ipcm.Backend, error) {
+	backends := []ipcm.Backend{
+		ipcm.DefaultBackend,
+		ipcm.AbstractSocketBackend,
+		ipcm.OFDBackend,
+	}
+
+	for _, backend := range backends {
+		if backend.String() == name {
+			return backend, nil
+		}
+	}
+
+	return ipcm.DefaultBackend, fmt.Errorf("unknown backend '%s'", name)
+}
+
+// lockNext locks a second mutex while the first one is held. It prints
+// "deadlock" if the lock attempt fails because a deadlock was detected.
+func lockNext(resource string, backend ipcm.Backend, deadlockRegistry string) error {
+	m, err := ipcm.NewMutex(ipcm.MutexConfig{
+		Resource:         resource,
+		Backend:          backend,
+		DeadlockRegistry: deadlockRegistry,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = m.LockContext(context.Background())
+	if err != nil {
+		if lockErr, ok := err.(*ipcm.LockError); ok && lockErr.DeadlockDetected() {
+			fmt.Println("deadlock")
+			return nil
+		}
+		return err
+	}
+
+	fmt.Println("locked next")
+
+	return nil
+}
+
+func doReadLock(resource string, loopForever bool) error {
+	m, err := ipcm.NewRWMutex(ipcm.MutexConfig{
+		Resource: resource,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = m.TimedTryRLock(1 * time.Second)
+	if err != nil {
+		return err
+	}
+	defer m.RUnlock()
+
+	if loopForever {
+		fmt.Println("ready")
 		for {
 			time.Sleep(1 * time.Second)
 		}
 	}
+
+	return nil
 }
 
 func doInterProcessCommunicationTest(m ipcm.Mutex, ipcValueFilePath string, maxValue int) error {
@@ -73,20 +198,20 @@ func doInterProcessCommunicationTest(m ipcm.Mutex, ipcValueFilePath string, maxV
 
 			raw, err := ioutil.ReadFile(ipcValueFilePath)
 			if err != nil {
-				errs <- fmt.Errorf("failed to read ipc test file - " + err.Error())
+				errs <- fmt.Errorf("failed to read ipc test file - %s", err.Error())
 				return
 			}
 
 			value, err := strconv.Atoi(string(raw))
 			if err != nil {
-				errs <- fmt.Errorf("failed to parse ipc file's value - " + err.Error())
+				errs <- fmt.Errorf("failed to parse ipc file's value - %s", err.Error())
 				return
 			}
 
 			value++
 			err = ioutil.WriteFile(ipcValueFilePath, []byte(strconv.Itoa(value)), 0600)
 			if err != nil {
-				errs <- fmt.Errorf("failed to write to ipc test file - " + err.Error())
+				errs <- fmt.Errorf("failed to write to ipc test file - %s", err.Error())
 				return
 			}
 		}()
@@ -108,3 +233,54 @@ func doInterProcessCommunicationTest(m ipcm.Mutex, ipcValueFilePath string, maxV
 
 	return nil
 }
+
+// doLockOrderTest waits for the order file to be created, and then locks
+// the mutex the specified number of times. Each time, the process ID is
+// appended to the order file while the mutex is held.
+func doLockOrderTest(ctx context.Context, m ipcm.Mutex, orderFilePath string, iterations int, hold time.Duration) error {
+	if iterations < 1 {
+		return fmt.Errorf("iterations must be greater than 0")
+	}
+
+	for {
+		_, err := os.Stat(orderFilePath)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	line := []byte(strconv.Itoa(os.Getpid()) + "\n")
+
+	for i := 0; i < iterations; i++ {
+		err := appendWhileLocked(ctx, m, orderFilePath, line, hold)
+		if err != nil {
+			return fmt.Errorf("failed to record lock order - %s", err.Error())
+		}
+	}
+
+	return nil
+}
+
+func appendWhileLocked(ctx context.Context, m ipcm.Mutex, filePath string, data []byte, hold time.Duration) error {
+	err := m.LockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer m.Unlock()
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(hold)
+
+	return nil
+}
@@ -28,6 +28,14 @@ type testHarnessOptions struct {
 	// loopForever, when true, will make the test harness loop forever.
 	loopForever bool
 
+	// readLock, when true, will make the test harness lock an RWMutex
+	// for reading instead of locking a Mutex.
+	readLock bool
+
+	// label is the label the test harness records when locking the
+	// mutex.
+	label string
+
 	// ipcFilePath is the file to write inter-process communication
 	// values to. When this is specified, the test harness will run
 	// in the ipc test mode. This means the test harness will spawn
@@ -37,6 +45,25 @@ type testHarnessOptions struct {
 	// ipcValue is the maximum amount of times the test harness should
 	// increment the ipc test value.
 	ipcValue int
+
+	// orderFilePath is the file to record the lock order to. When this
+	// is specified, the test harness will wait for the file to be
+	// created, and then lock the mutex iterations times, appending its
+	// PID to the file each time.
+	orderFilePath string
+
+	// iterations is the number of times the test harness should lock
+	// the mutex when recording the lock order.
+	iterations int
+
+	// priority is the priority the test harness locks the mutex with
+	// when recording the lock order.
+	priority int
+
+	// nextResource is the resource of a second mutex that the test
+	// harness locks after it has locked the mutex and reported that
+	// it is ready. Only applies when loopForever is true.
+	nextResource string
 }
 
 func (o testHarnessOptions) args(t *testing.T) []string {
@@ -46,21 +73,66 @@ func (o testHarnessOptions) args(t *testing.T) []string {
 
 	args := []string{"-resource", o.config.Resource}
 
+	if o.config.Backend != DefaultBackend {
+		args = append(args, "-backend", o.config.Backend.String())
+	}
+
+	if o.config.LeaseTTL > 0 {
+		args = append(args, "-lease", o.config.LeaseTTL.String())
+	}
+
+	if o.config.FencingTokens {
+		args = append(args, "-tokens")
+	}
+
+	if o.config.Fair {
+		args = append(args, "-fair")
+	}
+
+	if o.config.PriorityAging > 0 {
+		args = append(args, "-aging", o.config.PriorityAging.String())
+	}
+
+	if len(o.config.DeadlockRegistry) > 0 {
+		args = append(args, "-registry", o.config.DeadlockRegistry)
+	}
+
 	if o.loopForever {
 		args = append(args, "-loop")
 	}
 
+	if o.readLock {
+		args = append(args, "-rlock")
+	}
+
+	if len(o.label) > 0 {
+		args = append(args, "-label", o.label)
+	}
+
 	if len(o.ipcFilePath) > 0 {
 		args = append(args, "-ipcfile", o.ipcFilePath)
 		args = append(args, "-ipcvalue", strconv.Itoa(o.ipcValue))
 	}
 
+	if len(o.orderFilePath) > 0 {
+		args = append(args, "-orderfile", o.orderFilePath)
+		args = append(args, "-iterations", strconv.Itoa(o.iterations))
+	}
+
+	if o.priority != 0 {
+		args = append(args, "-priority", strconv.Itoa(o.priority))
+	}
+
+	if len(o.nextResource) > 0 {
+		args = append(args, "-next", o.nextResource)
+	}
+
 	return args
 }
 
 // setupTestEnv creates the test data directory and gets information about
 // the repository.
-func setupTestEnv(t *testing.T) testEnv {
+func setupTestEnv(t testing.TB) testEnv {
 	dirPath, err := os.Getwd()
 	if err != nil {
 		t.Fatalf("failed to get current working directory for testing - %s", err.Error())
@@ -93,6 +165,32 @@ func setupTestEnv(t *testing.T) testEnv {
 	}
 }
 
+// supportedBackends returns the backends that are supported on the
+// current operating system.
+func supportedBackends(t *testing.T) []Backend {
+	env := setupTestEnv(t)
+
+	var supported []Backend
+
+	for _, backend := range []Backend{DefaultBackend, AbstractSocketBackend, OFDBackend} {
+		config := env.mutexConfig
+		config.Backend = backend
+
+		m, err := NewMutex(config)
+		if err != nil {
+			if configErr, ok := err.(*ConfigureError); ok && configErr.NotSupported() {
+				continue
+			}
+			t.Fatalf("failed to create mutex using %s backend - %s", backend.String(), err.Error())
+		}
+
+		m.Close()
+		supported = append(supported, backend)
+	}
+
+	return supported
+}
+
 // compileTestHarness compiles the test harness application and returns
 // an *exec.Cmd representing the test harness with the provided
 // testHarnessOptions. The returned Cmd must be started by the caller.
@@ -124,10 +222,30 @@ func compileTestHarness(env testEnv, options testHarnessOptions, t *testing.T) *
 //
 // Callers are responsible for the lifecycle of the returned process.
 func newProcessLocksAndIdles(env testEnv, t *testing.T) *exec.Cmd {
-	o := testHarnessOptions{
+	return startIdleTestHarness(env, testHarnessOptions{
 		config:      env.mutexConfig,
 		loopForever: true,
-	}
+	}, t)
+}
+
+// newProcessRLocksAndIdles is similar to newProcessLocksAndIdles, except
+// the test harness locks an RWMutex for reading.
+func newProcessRLocksAndIdles(env testEnv, t *testing.T) *exec.Cmd {
+	return startIdleTestHarness(env, testHarnessOptions{
+		config:      env.mutexConfig,
+		loopForever: true,
+		readLock:    true,
+	}, t)
+}
+
+// startIdleTestHarness compiles and starts the test harness with the
+// provided testHarnessOptions. It waits until the test harness reports
+// that it is ready (i.e., it has locked the mutex and is idling).
+//
+// The current unit test will fail if any of these operations fail.
+//
+// Callers are responsible for the lifecycle of the returned process.
+func startIdleTestHarness(env testEnv, o testHarnessOptions, t *testing.T) *exec.Cmd {
 	testHarness := compileTestHarness(env, o, t)
 
 	// Need to start test harness async. We need to be able to
@@ -0,0 +1,103 @@
+package ipcm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// WaitForEdge is part of a cycle in the wait-for graph. The process
+// waits for the resource, which is held by the process of the next
+// WaitForEdge in the cycle (the last WaitForEdge wraps around to the
+// first).
+type WaitForEdge struct {
+	// PID is the process ID of the waiting process.
+	PID int
+
+	// Resource is the resource the process waits for.
+	Resource string
+}
+
+// waitForGraph describes which resources processes hold and wait for.
+type waitForGraph struct {
+	holders map[string][]int
+	waits   map[int][]string
+}
+
+func newWaitForGraph() *waitForGraph {
+	return &waitForGraph{
+		holders: make(map[string][]int),
+		waits:   make(map[int][]string),
+	}
+}
+
+// add records the resources that a process holds and waits for.
+func (o *waitForGraph) add(pid int, holds []string, waits []string) {
+	for _, resource := range holds {
+		o.holders[resource] = append(o.holders[resource], pid)
+	}
+
+	o.waits[pid] = append(o.waits[pid], waits...)
+}
+
+// cycleFrom returns a cycle that the process is part of, or nil if there
+// is no such cycle. The first WaitForEdge of the cycle belongs to the
+// specified process.
+func (o *waitForGraph) cycleFrom(pid int) []WaitForEdge {
+	visited := make(map[int]bool)
+
+	var search func(current int, path []WaitForEdge) []WaitForEdge
+	search = func(current int, path []WaitForEdge) []WaitForEdge {
+		visited[current] = true
+
+		waits := append([]string(nil), o.waits[current]...)
+		sort.Strings(waits)
+
+		for _, resource := range waits {
+			edgePath := append(path, WaitForEdge{
+				PID:      current,
+				Resource: resource,
+			})
+
+			for _, holder := range o.holders[resource] {
+				if holder == current {
+					// Routines within the same process wait for
+					// each other in the in-process mutex.
+					continue
+				}
+
+				if holder == pid {
+					return edgePath
+				}
+
+				if visited[holder] {
+					continue
+				}
+
+				cycle := search(holder, edgePath)
+				if cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return search(pid, nil)
+}
+
+func newDeadlockError(cycle []WaitForEdge) *LockError {
+	var parts []string
+	for i, edge := range cycle {
+		next := cycle[(i+1)%len(cycle)]
+		parts = append(parts, fmt.Sprintf("pid %d waits for '%s' held by pid %d",
+			edge.PID, edge.Resource, next.PID))
+	}
+
+	return &LockError{
+		reason: fmt.Sprintf("%s deadlock detected - %s",
+			unableToAcquirePrefix, strings.Join(parts, ", ")),
+		deadlock: cycle,
+	}
+}
@@ -0,0 +1,62 @@
+package ipcm
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWaitForGraph_CycleFrom(t *testing.T) {
+	graph := newWaitForGraph()
+	graph.add(1, []string{"a"}, []string{"b"})
+	graph.add(2, []string{"b"}, []string{"c"})
+	graph.add(3, []string{"c"}, []string{"a"})
+	graph.add(4, []string{"d"}, []string{"a"})
+
+	expected := []WaitForEdge{
+		{PID: 1, Resource: "b"},
+		{PID: 2, Resource: "c"},
+		{PID: 3, Resource: "a"},
+	}
+
+	cycle := graph.cycleFrom(1)
+	if !reflect.DeepEqual(cycle, expected) {
+		t.Fatalf("expected cycle %v - got %v", expected, cycle)
+	}
+
+	cycle = graph.cycleFrom(4)
+	if cycle != nil {
+		t.Fatalf("process waiting on a cycle is not part of it - got %v", cycle)
+	}
+}
+
+func TestWaitForGraph_CycleFromSkipsOwnHolds(t *testing.T) {
+	graph := newWaitForGraph()
+	graph.add(1, []string{"a"}, []string{"a"})
+	graph.add(2, []string{"a"}, nil)
+
+	cycle := graph.cycleFrom(1)
+	if cycle != nil {
+		t.Fatalf("routines of the same process should not form a cycle - got %v", cycle)
+	}
+}
+
+func TestNewDeadlockError(t *testing.T) {
+	cycle := []WaitForEdge{
+		{PID: 1, Resource: "b"},
+		{PID: 2, Resource: "a"},
+	}
+
+	err := newDeadlockError(cycle)
+	if !err.DeadlockDetected() {
+		t.Fatal("error should report that a deadlock was detected")
+	}
+
+	if !reflect.DeepEqual(err.DeadlockCycle(), cycle) {
+		t.Fatalf("expected cycle %v - got %v", cycle, err.DeadlockCycle())
+	}
+
+	expected := "failed to acquire mutex - deadlock detected - pid 1 waits for 'b' held by pid 2, pid 2 waits for 'a' held by pid 1"
+	if err.Error() != expected {
+		t.Fatalf("expected error '%s' - got '%s'", expected, err.Error())
+	}
+}
@@ -0,0 +1,292 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	deadlockRecordSuffix = ".json"
+)
+
+var (
+	deadlockRegistriesMutex sync.Mutex
+	deadlockRegistries      = make(map[string]*deadlockRegistry)
+)
+
+// deadlockRecord is the content of a process's file in a deadlock
+// registry directory.
+type deadlockRecord struct {
+	PID   int      `json:"pid"`
+	Holds []string `json:"holds"`
+	Waits []string `json:"waits"`
+}
+
+// deadlockRegistry records the resources that the current process holds
+// and waits for in a registry directory shared with other processes.
+// There is a single deadlockRegistry per directory in a process. It is
+// safe for concurrent use.
+type deadlockRegistry struct {
+	mutex sync.Mutex
+	dir   string
+	pid   int
+	holds map[string]int
+	waits map[string]int
+}
+
+// deadlockRegistryFor returns the current process's deadlockRegistry for
+// the MutexConfig's DeadlockRegistry directory, creating the directory
+// if needed.
+func deadlockRegistryFor(config MutexConfig) (*deadlockRegistry, error) {
+	dir := config.DeadlockRegistry
+
+	if !path.IsAbs(dir) || len(dir) == 1 {
+		return nil, &ConfigureError{
+			reason: fmt.Sprintf("%s the specified deadlock registry is not a fully qualified directory path - '%s'",
+				configureErrPrefix, dir),
+			notAbs: true,
+		}
+	}
+
+	dir = path.Clean(dir)
+
+	deadlockRegistriesMutex.Lock()
+	defer deadlockRegistriesMutex.Unlock()
+
+	registry, ok := deadlockRegistries[dir]
+	if ok {
+		return registry, nil
+	}
+
+	err := os.MkdirAll(dir, dirMode)
+	if err != nil {
+		return nil, &LockError{
+			reason:  fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
+			dirFail: true,
+		}
+	}
+
+	registry = &deadlockRegistry{
+		dir:   dir,
+		pid:   os.Getpid(),
+		holds: make(map[string]int),
+		waits: make(map[string]int),
+	}
+
+	deadlockRegistries[dir] = registry
+
+	return registry, nil
+}
+
+// lock records that the current process waits for the resource, and
+// calls lockFn. While lockFn waits, the wait-for graph is checked for
+// a cycle that includes the current process every osMutexRetryInterval.
+// If a cycle is found, lockFn's context is cancelled and a *LockError
+// describing the cycle is returned. If lockFn succeeds, the resource is
+// recorded as held until release is called.
+//
+// Lock attempts that cannot return an error (refer to blockingContext)
+// are recorded, but never given up.
+func (o *deadlockRegistry) lock(ctx context.Context, resource string, lockFn func(context.Context) error) error {
+	o.update(func() {
+		o.waits[resource]++
+	})
+
+	if isBlocking(ctx) {
+		err := lockFn(ctx)
+		o.update(func() {
+			decrement(o.waits, resource)
+			if err == nil {
+				o.holds[resource]++
+			}
+		})
+		return err
+	}
+
+	cycle := o.findCycle()
+	if cycle != nil {
+		o.update(func() {
+			decrement(o.waits, resource)
+		})
+		return newDeadlockError(cycle)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	detected := make(chan []WaitForEdge, 1)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(osMutexRetryInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				cycle := o.findCycle()
+				if cycle != nil {
+					detected <- cycle
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	err := lockFn(ctx)
+	close(done)
+
+	o.update(func() {
+		decrement(o.waits, resource)
+		if err == nil {
+			o.holds[resource]++
+		}
+	})
+
+	if err != nil {
+		select {
+		case cycle := <-detected:
+			return newDeadlockError(cycle)
+		default:
+		}
+	}
+
+	return err
+}
+
+// release records that the current process no longer holds the resource.
+func (o *deadlockRegistry) release(resource string) {
+	o.update(func() {
+		decrement(o.holds, resource)
+	})
+}
+
+// update applies fn to the registry's state and persists the result.
+// Failing to persist the state does not prevent the Mutex from being
+// locked - it only prevents other processes from detecting a deadlock
+// that includes the current process.
+func (o *deadlockRegistry) update(fn func()) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	fn()
+
+	recordPath := path.Join(o.dir, strconv.Itoa(o.pid)+deadlockRecordSuffix)
+
+	if len(o.holds) == 0 && len(o.waits) == 0 {
+		os.Remove(recordPath)
+		return
+	}
+
+	raw, err := json.Marshal(o.recordUnsafe())
+	if err != nil {
+		return
+	}
+
+	replaceFile(recordPath, raw)
+}
+
+// recordUnsafe returns the deadlockRecord of the current process. The
+// registry's mutex must be locked.
+func (o *deadlockRegistry) recordUnsafe() deadlockRecord {
+	return deadlockRecord{
+		PID:   o.pid,
+		Holds: sortedKeys(o.holds),
+		Waits: sortedKeys(o.waits),
+	}
+}
+
+// findCycle builds the wait-for graph from the registry directory and
+// returns a cycle that includes the current process, or nil if there is
+// no such cycle. Records of processes that no longer exist are removed.
+func (o *deadlockRegistry) findCycle() []WaitForEdge {
+	o.mutex.Lock()
+	own := o.recordUnsafe()
+	o.mutex.Unlock()
+
+	graph := newWaitForGraph()
+	graph.add(own.PID, own.Holds, own.Waits)
+
+	infos, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return nil
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, deadlockRecordSuffix) {
+			continue
+		}
+
+		pid, err := strconv.Atoi(strings.TrimSuffix(name, deadlockRecordSuffix))
+		if err != nil || pid == own.PID {
+			continue
+		}
+
+		recordPath := path.Join(o.dir, name)
+
+		if !isProcessAlive(pid) {
+			os.Remove(recordPath)
+			continue
+		}
+
+		raw, err := ioutil.ReadFile(recordPath)
+		if err != nil {
+			continue
+		}
+
+		var record deadlockRecord
+		err = json.Unmarshal(raw, &record)
+		if err != nil || record.PID != pid {
+			continue
+		}
+
+		graph.add(record.PID, record.Holds, record.Waits)
+	}
+
+	return graph.cycleFrom(own.PID)
+}
+
+// isProcessAlive returns true if a process with the specified PID
+// exists. A process that exists but cannot be signalled by the current
+// process is considered alive.
+func isProcessAlive(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// decrement decrements the count of the key, removing the key when its
+// count reaches zero.
+func decrement(counts map[string]int, key string) {
+	counts[key]--
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,263 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewMutex_DeadlockDetected(t *testing.T) {
+	env := setupTestEnv(t)
+	registry := env.mutexConfig.Resource + ".registry"
+	defer os.RemoveAll(registry)
+
+	configA := env.mutexConfig
+	configA.DeadlockRegistry = registry
+
+	configB := configA
+	configB.Resource = env.mutexConfig.Resource + "-b"
+
+	b, err := NewMutex(configB)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	b.Lock()
+	defer b.Unlock()
+
+	// The harness locks A, and then waits for B.
+	testHarness := startIdleTestHarness(env, testHarnessOptions{
+		config:       configA,
+		loopForever:  true,
+		nextResource: configB.Resource,
+	}, t)
+	defer testHarness.Process.Kill()
+
+	harnessPID := testHarness.Process.Pid
+	waitForDeadlockRecord(t, registry, harnessPID, configB.Resource)
+
+	a, err := NewMutex(configA)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	err = a.TimedTryLock(5 * time.Second)
+	if err == nil {
+		a.Unlock()
+		t.Fatal("lock should have failed because of a deadlock")
+	}
+
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.DeadlockDetected() {
+		t.Fatalf("expected a deadlock error - got %v", err)
+	}
+
+	expected := []WaitForEdge{
+		{PID: os.Getpid(), Resource: configA.Resource},
+		{PID: harnessPID, Resource: configB.Resource},
+	}
+
+	if !reflect.DeepEqual(lockErr.DeadlockCycle(), expected) {
+		t.Fatalf("expected cycle %v - got %v", expected, lockErr.DeadlockCycle())
+	}
+}
+
+func TestNewMutex_DeadlockLockKeepsWaiting(t *testing.T) {
+	env := setupTestEnv(t)
+	registry := env.mutexConfig.Resource + ".registry"
+	defer os.RemoveAll(registry)
+
+	configA := env.mutexConfig
+	configA.DeadlockRegistry = registry
+
+	configB := configA
+	configB.Resource = env.mutexConfig.Resource + "-b"
+
+	b, err := NewMutex(configB)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	b.Lock()
+	defer b.Unlock()
+
+	// The harness locks A, and then waits for B.
+	testHarness := startIdleTestHarness(env, testHarnessOptions{
+		config:       configA,
+		loopForever:  true,
+		nextResource: configB.Resource,
+	}, t)
+	defer testHarness.Process.Kill()
+
+	waitForDeadlockRecord(t, registry, testHarness.Process.Pid, configB.Resource)
+
+	a, err := NewMutex(configA)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	locked := make(chan struct{})
+	go func() {
+		a.Lock()
+		close(locked)
+	}()
+
+	// Lock must keep waiting, and must still record the wait.
+	recordPath := path.Join(registry, strconv.Itoa(os.Getpid())+deadlockRecordSuffix)
+	time.Sleep(5 * osMutexRetryInterval)
+	record := readDeadlockRecord(t, recordPath)
+	if !reflect.DeepEqual(record.Waits, []string{configA.Resource}) {
+		t.Fatalf("record should contain the wait for '%s' - got %+v", configA.Resource, record)
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	select {
+	case <-locked:
+		a.Unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock should have succeeded once the other process exited")
+	}
+}
+
+func TestNewMutex_DeadlockRegistryRecordsHolds(t *testing.T) {
+	env := setupTestEnv(t)
+	registry := env.mutexConfig.Resource + ".registry"
+	defer os.RemoveAll(registry)
+
+	env.mutexConfig.DeadlockRegistry = registry
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	recordPath := path.Join(registry, strconv.Itoa(os.Getpid())+deadlockRecordSuffix)
+
+	m.Lock()
+
+	record := readDeadlockRecord(t, recordPath)
+	if !reflect.DeepEqual(record.Holds, []string{env.mutexConfig.Resource}) || len(record.Waits) > 0 {
+		t.Fatalf("record should only contain the held resource - got %+v", record)
+	}
+
+	m.Unlock()
+
+	_, err = os.Stat(recordPath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("record should have been removed once nothing is held - got %v", err)
+	}
+}
+
+func TestNewMutex_DeadlockRegistryRemovesStaleRecords(t *testing.T) {
+	env := setupTestEnv(t)
+	registry := env.mutexConfig.Resource + ".registry"
+	defer os.RemoveAll(registry)
+
+	env.mutexConfig.DeadlockRegistry = registry
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	exited := exec.Command("true")
+	err = exited.Run()
+	if err != nil {
+		t.Fatalf("failed to run process - %s", err.Error())
+	}
+
+	// A process that exited while waiting for the resource held by
+	// this process must not be mistaken for a deadlock.
+	stalePID := exited.ProcessState.Pid()
+	raw, err := json.Marshal(deadlockRecord{
+		PID:   stalePID,
+		Holds: []string{env.mutexConfig.Resource + "-b"},
+		Waits: []string{env.mutexConfig.Resource},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	stalePath := path.Join(registry, strconv.Itoa(stalePID)+deadlockRecordSuffix)
+	err = ioutil.WriteFile(stalePath, raw, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = m.TimedTryLock(time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded - %s", err.Error())
+	}
+	defer m.Unlock()
+
+	_, err = os.Stat(stalePath)
+	if !os.IsNotExist(err) {
+		t.Fatalf("stale record should have been removed - got %v", err)
+	}
+}
+
+func TestNewMutex_DeadlockRegistryNotAbs(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.DeadlockRegistry = "registry"
+
+	_, err := NewMutex(env.mutexConfig)
+	configErr, ok := err.(*ConfigureError)
+	if !ok || !configErr.PathNotFullyQualified() {
+		t.Fatalf("expected a path not fully qualified error - got %v", err)
+	}
+}
+
+// waitForDeadlockRecord waits until the process's record in the deadlock
+// registry shows that it waits for the resource.
+func waitForDeadlockRecord(t *testing.T, registry string, pid int, resource string) {
+	recordPath := path.Join(registry, strconv.Itoa(pid)+deadlockRecordSuffix)
+
+	start := time.Now()
+	for {
+		raw, err := ioutil.ReadFile(recordPath)
+		if err == nil {
+			var record deadlockRecord
+			err = json.Unmarshal(raw, &record)
+			if err == nil && reflect.DeepEqual(record.Waits, []string{resource}) {
+				return
+			}
+		}
+
+		if time.Since(start) >= 5*time.Second {
+			t.Fatalf("process %d did not wait for '%s' in time", pid, resource)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readDeadlockRecord(t *testing.T, recordPath string) deadlockRecord {
+	raw, err := ioutil.ReadFile(recordPath)
+	if err != nil {
+		t.Fatalf("failed to read record - %s", err.Error())
+	}
+
+	var record deadlockRecord
+	err = json.Unmarshal(raw, &record)
+	if err != nil {
+		t.Fatalf("failed to parse record - %s", err.Error())
+	}
+
+	return record
+}
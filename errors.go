@@ -1,9 +1,10 @@
 package ipcm
 
 type ConfigureError struct {
-	reason     string
-	noResource bool
-	notAbs     bool
+	reason       string
+	noResource   bool
+	notAbs       bool
+	notSupported bool
 }
 
 func (o *ConfigureError) Error() string {
@@ -18,21 +19,40 @@ func (o *ConfigureError) PathNotFullyQualified() bool {
 	return o.notAbs
 }
 
+func (o *ConfigureError) NotSupported() bool {
+	return o.notSupported
+}
+
 type LockError struct {
-	reason        string
-	createFail    bool
-	dirFail       bool
-	dllLoadFail   bool
-	procLoadFail  bool
-	syncTimeout   bool
-	systemTimeout bool
-	syscallFailed bool
+	reason           string
+	owner            *OwnerInfo
+	createFail       bool
+	dirFail          bool
+	dllLoadFail      bool
+	procLoadFail     bool
+	syncTimeout      bool
+	systemTimeout    bool
+	syscallFailed    bool
+	closed           bool
+	retriesExhausted bool
+	tokenCorrupt     bool
+	deadlock         []WaitForEdge
 }
 
 func (o *LockError) Error() string {
 	return o.reason
 }
 
+// Owner returns information about the holder of the mutex when the lock
+// attempt failed, if it is known.
+func (o *LockError) Owner() (OwnerInfo, bool) {
+	if o.owner == nil {
+		return OwnerInfo{}, false
+	}
+
+	return *o.owner, true
+}
+
 func (o *LockError) FailedToCreated() bool {
 	return o.createFail
 }
@@ -60,3 +80,100 @@ func (o *LockError) SystemMutexLockTimedOut() bool {
 func (o *LockError) SystemCallFailed() bool {
 	return o.syscallFailed
 }
+
+func (o *LockError) MutexClosed() bool {
+	return o.closed
+}
+
+// DeadlockDetected returns true if the lock attempt was given up because
+// waiting for the Mutex would have resulted in a deadlock.
+func (o *LockError) DeadlockDetected() bool {
+	return len(o.deadlock) > 0
+}
+
+// DeadlockCycle returns the cycle of processes and resources that the
+// lock attempt would have completed, if a deadlock was detected.
+func (o *LockError) DeadlockCycle() []WaitForEdge {
+	return o.deadlock
+}
+
+// RetriesExhausted returns true if the lock attempt was given up because
+// the MutexConfig's RetryPolicy does not allow any more attempts.
+func (o *LockError) RetriesExhausted() bool {
+	return o.retriesExhausted
+}
+
+// FencingTokenCorrupt returns true if the lock attempt failed because
+// the file that stores the Mutex's last fencing token does not contain
+// a valid token. Refer to MutexConfig.FencingTokens.
+func (o *LockError) FencingTokenCorrupt() bool {
+	return o.tokenCorrupt
+}
+
+type UnlockError struct {
+	reason        string
+	syscallFailed bool
+	leaseLost     bool
+}
+
+func (o *UnlockError) Error() string {
+	return o.reason
+}
+
+// SystemCallFailed returns true if the OS mutex could not be unlocked.
+func (o *UnlockError) SystemCallFailed() bool {
+	return o.syscallFailed
+}
+
+// LeaseLost returns true if the Mutex's lease lapsed before the Mutex
+// was unlocked, meaning that another routine may have held the Mutex at
+// the same time.
+func (o *UnlockError) LeaseLost() bool {
+	return o.leaseLost
+}
+
+type CloseError struct {
+	reason        string
+	locked        bool
+	closed        bool
+	syscallFailed bool
+}
+
+func (o *CloseError) Error() string {
+	return o.reason
+}
+
+func (o *CloseError) StillLocked() bool {
+	return o.locked
+}
+
+func (o *CloseError) AlreadyClosed() bool {
+	return o.closed
+}
+
+func (o *CloseError) SystemCallFailed() bool {
+	return o.syscallFailed
+}
+
+type QueryError struct {
+	reason       string
+	notHeld      bool
+	noOwnerInfo  bool
+	notSupported bool
+}
+
+func (o *QueryError) Error() string {
+	return o.reason
+}
+
+func (o *QueryError) NotHeld() bool {
+	return o.notHeld
+}
+
+func (o *QueryError) OwnerNotRecorded() bool {
+	return o.noOwnerInfo
+}
+
+func (o *QueryError) NotSupported() bool {
+	return o.notSupported
+}
@@ -0,0 +1,498 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	queueFileSuffix   = ".queue"
+	ticketsDirSuffix  = ".tickets"
+	ticketTempPattern = "tmp."
+
+	// maxPriorityAging keeps the ticket order key from overflowing.
+	maxPriorityAging = time.Duration(math.MaxInt64 / 4 / MaxPriority)
+)
+
+// ticketID identifies a ticket and determines its place in the queue.
+// Tickets are ordered by their key, which is the value of the queue
+// clock when the ticket was taken, adjusted for the waiter's priority.
+// Tickets that have the same key are ordered by their number.
+type ticketID struct {
+	key    int64
+	number uint64
+}
+
+// name returns the name of the ticket's file.
+func (o ticketID) name() string {
+	return strconv.FormatInt(o.key, 10) + "." + strconv.FormatUint(o.number, 10)
+}
+
+// before returns true if the ticket is ahead of the other ticket in the
+// queue.
+func (o ticketID) before(other ticketID) bool {
+	if o.key != other.key {
+		return o.key < other.key
+	}
+
+	return o.number < other.number
+}
+
+// parseTicketID parses the name of a ticket file.
+func parseTicketID(name string) (ticketID, error) {
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return ticketID{}, fmt.Errorf("invalid ticket name '%s'", name)
+	}
+
+	key, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	number, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	return ticketID{
+		key:    key,
+		number: number,
+	}, nil
+}
+
+// fairLocker is an osLocker that grants the lock to processes in the
+// order in which they started waiting for it, adjusted for their
+// priority.
+//
+// A waiter takes a ticket by advancing the counter and the clock stored
+// in the queue file (refer to advanceQueueClock). The ticket is a file
+// in the tickets directory named after the ticketID. The waiter holds
+// an exclusive flock(2) lock on its ticket file for as long as it waits
+// for, and holds, the lock.
+// It waits for its turn by blocking on the ticket of its closest
+// predecessor. A ticket file that is not locked was left behind by
+// a process that exited, and is ignored (and removed).
+//
+// Like other osLocker implementations, it is not safe for concurrent
+// use.
+//
+// A waiter that is abandoned because the context is done is kept, so
+// that the next lock attempt can adopt it if it waits for the same
+// ticket (refer to lockWaiter).
+type fairLocker struct {
+	locker       osLocker
+	queuePath    string
+	ticketsDir   string
+	aging        time.Duration
+	retry        RetryPolicy
+	ticket       *os.File
+	id           ticketID
+	waiter       *lockWaiter
+	waiterTicket string
+}
+
+func newFairLocker(locker osLocker, config MutexConfig) *fairLocker {
+	aging := config.PriorityAging
+	if aging == 0 {
+		aging = defaultPriorityAging
+	}
+	if aging > maxPriorityAging {
+		aging = maxPriorityAging
+	}
+
+	return &fairLocker{
+		locker:     locker,
+		queuePath:  config.Resource + queueFileSuffix,
+		ticketsDir: config.Resource + ticketsDirSuffix,
+		aging:      aging,
+		retry:      config.RetryPolicy,
+	}
+}
+
+func (o *fairLocker) lock(ctx context.Context) error {
+	err := o.waitForTurn(ctx, priorityFromContext(ctx))
+	if err != nil {
+		o.dropTicket()
+		return err
+	}
+
+	err = o.locker.lock(ctx)
+	if err != nil {
+		o.dropTicket()
+		return err
+	}
+
+	return nil
+}
+
+// waitForTurn takes a ticket and waits until none of the tickets ahead
+// of it are held. Errors that retrying cannot resolve are returned right
+// away, except to lock methods that cannot return an error.
+func (o *fairLocker) waitForTurn(ctx context.Context, priority int) error {
+	backoff := o.retry.newBackoff()
+
+	for {
+		var err error
+		if o.ticket == nil {
+			err = o.takeTicket(priority)
+		}
+
+		var predecessor string
+		if err == nil {
+			predecessor, err = o.livePredecessor()
+		}
+
+		if err == nil && len(predecessor) == 0 {
+			return nil
+		}
+
+		if err == nil {
+			err = o.waitForTicket(ctx, predecessor)
+			if err == nil {
+				continue
+			}
+			if err == ctx.Err() {
+				return err
+			}
+		}
+
+		// Lock methods that cannot return an error keep trying.
+		if o.isPermanentError(err) && !isBlocking(ctx) {
+			return &LockError{
+				reason: fmt.Sprintf("%s failed to access the fair queue - %s",
+					unableToAcquirePrefix, err.Error()),
+				syscallFailed: true,
+			}
+		}
+
+		err = backoff.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// takeTicket takes the next ticket. The ticket file is locked before it
+// is moved into the tickets directory so that other waiters never see
+// it unlocked.
+func (o *fairLocker) takeTicket(priority int) error {
+	err := os.MkdirAll(o.ticketsDir, dirMode)
+	if err != nil {
+		return err
+	}
+
+	ticket, err := ioutil.TempFile(o.ticketsDir, ticketTempPattern)
+	if err != nil {
+		return err
+	}
+
+	var id ticketID
+	err = flockRetryInterrupted(int(ticket.Fd()), unix.LOCK_EX)
+	if err == nil {
+		id, err = o.publishTicket(priority, ticket.Name())
+	}
+	if err != nil {
+		os.Remove(ticket.Name())
+		ticket.Close()
+		return err
+	}
+
+	o.ticket = ticket
+	o.id = id
+
+	return nil
+}
+
+// publishTicket advances the counter and the clock stored in the queue
+// file, and moves the ticket file at tempPath into the tickets directory
+// under the ID of the ticket with their new values. Publishing the
+// ticket before the queue file is unlocked guarantees that a waiter
+// never misses a ticket that is ahead of its own.
+func (o *fairLocker) publishTicket(priority int, tempPath string) (ticketID, error) {
+	queue, err := os.OpenFile(o.queuePath, os.O_RDWR|os.O_CREATE, lockMode)
+	if err != nil {
+		return ticketID{}, err
+	}
+	defer queue.Close()
+
+	// The queue file is only locked briefly, so there is no need to
+	// wait for it in a cancellable manner.
+	err = flockRetryInterrupted(int(queue.Fd()), unix.LOCK_EX)
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	raw, err := ioutil.ReadAll(queue)
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	number, clock, err := parseQueueState(string(raw))
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	number++
+	clock = advanceQueueClock(clock, time.Now().UnixNano())
+
+	err = queue.Truncate(0)
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	_, err = queue.WriteAt([]byte(strconv.FormatUint(number, 10)+" "+strconv.FormatInt(clock, 10)), 0)
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	id := ticketID{
+		key:    clock - int64(priority)*int64(o.aging),
+		number: number,
+	}
+
+	err = os.Rename(tempPath, path.Join(o.ticketsDir, id.name()))
+	if err != nil {
+		return ticketID{}, err
+	}
+
+	return id, nil
+}
+
+// parseQueueState parses the contents of a queue file, which are the
+// number of the last ticket and the queue clock, separated by a space.
+// The clock is zero if the file was written by a version that did not
+// keep one.
+func parseQueueState(raw string) (uint64, int64, error) {
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return 0, 0, nil
+	}
+
+	number, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(fields) == 1 {
+		return number, 0, nil
+	}
+
+	clock, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return number, clock, nil
+}
+
+// advanceQueueClock returns the next value of the queue clock. The clock
+// follows the system clock so that priority aging is measured in real
+// time, but it always moves forward, so that a ticket is never ordered
+// ahead of one that was taken before it when the system clock is set
+// back.
+func advanceQueueClock(clock int64, now int64) int64 {
+	if now > clock {
+		return now
+	}
+
+	return clock + 1
+}
+
+// livePredecessor returns the path of the ticket file that is ahead of
+// the current ticket and is still held. An empty string is returned if
+// there is no such ticket, meaning it is the current ticket's turn.
+func (o *fairLocker) livePredecessor() (string, error) {
+	infos, err := ioutil.ReadDir(o.ticketsDir)
+	if err != nil {
+		return "", err
+	}
+
+	var ahead []ticketID
+	for _, info := range infos {
+		id, err := parseTicketID(info.Name())
+		if err != nil || !id.before(o.id) {
+			continue
+		}
+		ahead = append(ahead, id)
+	}
+
+	// Check the closest tickets first.
+	sort.Slice(ahead, func(i int, j int) bool {
+		return ahead[j].before(ahead[i])
+	})
+
+	for _, id := range ahead {
+		ticketPath := path.Join(o.ticketsDir, id.name())
+
+		held, err := isTicketHeld(ticketPath)
+		if err != nil {
+			return "", err
+		}
+
+		if held {
+			return ticketPath, nil
+		}
+	}
+
+	return "", nil
+}
+
+// isPermanentError returns true if retrying would not resolve the error
+// encountered while accessing the queue, such as when the queue files
+// belong to another user, or when the resource's directory does not
+// exist.
+func (o *fairLocker) isPermanentError(err error) bool {
+	if os.IsPermission(err) {
+		return true
+	}
+
+	if os.IsNotExist(err) {
+		_, statErr := os.Stat(path.Dir(o.queuePath))
+		return os.IsNotExist(statErr)
+	}
+
+	return false
+}
+
+// waitForTicket waits until the ticket file is unlocked.
+func (o *fairLocker) waitForTicket(ctx context.Context, ticketPath string) error {
+	waiter := o.adoptWaiter(ticketPath)
+	if waiter == nil {
+		ticket, err := os.Open(ticketPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if ctx.Err() != nil {
+			ticket.Close()
+			return ctx.Err()
+		}
+
+		fd := int(ticket.Fd())
+		waiter = startLockWaiter(ticket, func(how int) error {
+			return unix.Flock(fd, how)
+		}, unix.LOCK_SH)
+	}
+
+	err := waiter.wait(ctx)
+	if err == ctx.Err() && err != nil {
+		o.waiter = waiter
+		o.waiterTicket = ticketPath
+		return err
+	}
+
+	waiter.file.Close()
+
+	return err
+}
+
+// adoptWaiter returns the abandoned waiter if it waits for the specified
+// ticket. Otherwise, the abandoned waiter (if any) is released, and nil
+// is returned.
+func (o *fairLocker) adoptWaiter(ticketPath string) *lockWaiter {
+	waiter := o.waiter
+	if waiter == nil {
+		return nil
+	}
+
+	o.waiter = nil
+
+	if o.waiterTicket == ticketPath && waiter.adopt(unix.LOCK_SH) {
+		return waiter
+	}
+
+	releaseWaiter(waiter)
+
+	return nil
+}
+
+// dropTicket gives up the current ticket, if any. The ticket file is
+// removed before it is unlocked so that it is never seen unlocked.
+func (o *fairLocker) dropTicket() {
+	if o.ticket == nil {
+		return
+	}
+
+	os.Remove(path.Join(o.ticketsDir, o.id.name()))
+	o.ticket.Close()
+	o.ticket = nil
+	o.id = ticketID{}
+}
+
+func (o *fairLocker) unlock() error {
+	err := o.locker.unlock()
+	o.dropTicket()
+
+	return err
+}
+
+func (o *fairLocker) close(remove bool) error {
+	o.dropTicket()
+
+	if o.waiter != nil {
+		releaseWaiter(o.waiter)
+		o.waiter = nil
+	}
+
+	return o.locker.close(remove)
+}
+
+// isTicketHeld returns true if the ticket file is locked. A ticket file
+// that is not locked was left behind by a process that exited, in which
+// case it is removed.
+func isTicketHeld(ticketPath string) (bool, error) {
+	ticket, err := os.Open(ticketPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer ticket.Close()
+
+	err = unix.Flock(int(ticket.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+	switch err {
+	case nil:
+		os.Remove(ticketPath)
+		return false, nil
+	case unix.EWOULDBLOCK:
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
+// releaseWaiter releases an abandoned waiter that will not be adopted.
+// Its file is closed once its lock call returns.
+func releaseWaiter(waiter *lockWaiter) {
+	if !waiter.detach() {
+		waiter.file.Close()
+	}
+}
+
+// flockRetryInterrupted calls flock(2), retrying if the call is
+// interrupted by a signal.
+func flockRetryInterrupted(fd int, how int) error {
+	for {
+		err := unix.Flock(fd, how)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNewMutex_Fair(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+
+	lines := runLockOrderTest(env, 4, 10, t)
+
+	// Once every process is waiting, no process may lock the mutex
+	// twice in a row until one of them is done.
+	firstDone := len(lines)
+	lastIndex := make(map[string]int)
+	for i, pid := range lines {
+		lastIndex[pid] = i
+	}
+	for _, i := range lastIndex {
+		if i < firstDone {
+			firstDone = i
+		}
+	}
+
+	for i := len(lastIndex); i < firstDone; i++ {
+		if lines[i] == lines[i-1] {
+			t.Fatalf("process %s locked the mutex twice in a row while others were waiting - order: %v",
+				lines[i], lines)
+		}
+	}
+}
+
+func TestNewMutex_FairAbandonedTicket(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	// Giving up on the lock must give up the ticket as well.
+	err = m.TimedTryLock(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while another process holds the mutex")
+	}
+
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	// The killed process' ticket must not block other processes.
+	err = other.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the other process exited - %s", err.Error())
+	}
+	other.Unlock()
+
+	infos, err := ioutil.ReadDir(env.mutexConfig.Resource + ticketsDirSuffix)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if len(infos) > 0 {
+		t.Fatalf("all tickets should have been removed - found %d", len(infos))
+	}
+}
+
+func TestNewMutex_FairCancelledWaitsAreReused(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	routines := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		err = m.TimedTryLock(10 * time.Millisecond)
+		if err == nil {
+			t.Fatal("lock attempt should have failed while another process holds the mutex")
+		}
+	}
+
+	// The cancelled lock attempts must share a single waiter.
+	if leaked := runtime.NumGoroutine() - routines; leaked > 1 {
+		t.Fatalf("cancelled lock attempts left %d routines behind", leaked)
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	err = m.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the other process exited - %s", err.Error())
+	}
+	m.Unlock()
+}
+
+func TestFairLocker_IsPermanentError(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+
+	existing := newFairLocker(nil, env.mutexConfig)
+
+	env.mutexConfig.Resource = path.Join(env.mutexConfig.Resource, "missing", "resource")
+	missing := newFairLocker(nil, env.mutexConfig)
+
+	notExist := &os.PathError{Op: "open", Path: missing.queuePath, Err: unix.ENOENT}
+
+	testCases := []struct {
+		name      string
+		locker    *fairLocker
+		err       error
+		permanent bool
+	}{
+		{"permission denied", existing, &os.PathError{Op: "open", Path: existing.queuePath, Err: unix.EACCES}, true},
+		{"missing directory", missing, notExist, true},
+		{"missing file", existing, notExist, false},
+		{"other error", existing, &os.PathError{Op: "open", Path: existing.queuePath, Err: unix.EIO}, false},
+	}
+
+	for _, tc := range testCases {
+		if tc.locker.isPermanentError(tc.err) != tc.permanent {
+			t.Fatalf("%s - expected permanent to be %t", tc.name, tc.permanent)
+		}
+	}
+}
+
+func TestFairLocker_PublishTicketClockSetBack(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+
+	locker := newFairLocker(nil, env.mutexConfig)
+
+	err := os.MkdirAll(locker.ticketsDir, 0700)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// A queue clock that is ahead of the system clock behaves as if the
+	// system clock was set back after the last ticket was taken.
+	future := time.Now().Add(time.Hour).UnixNano()
+	err = ioutil.WriteFile(locker.queuePath, []byte("5 "+strconv.FormatInt(future, 10)), 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var previous ticketID
+	for i := 0; i < 3; i++ {
+		ticket, err := ioutil.TempFile(locker.ticketsDir, ticketTempPattern)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		ticket.Close()
+
+		id, err := locker.publishTicket(0, ticket.Name())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if id.key <= future || (i > 0 && !previous.before(id)) {
+			t.Fatalf("ticket %s was not ordered after ticket %s", id.name(), previous.name())
+		}
+
+		previous = id
+	}
+
+	if previous.number != 8 {
+		t.Fatalf("expected ticket number 8 - got %d", previous.number)
+	}
+}
+
+func TestParseQueueState(t *testing.T) {
+	testCases := []struct {
+		raw    string
+		number uint64
+		clock  int64
+		fails  bool
+	}{
+		{"", 0, 0, false},
+		{"12", 12, 0, false},
+		{"12 345", 12, 345, false},
+		{"12 x", 0, 0, true},
+		{"x", 0, 0, true},
+	}
+
+	for _, tc := range testCases {
+		number, clock, err := parseQueueState(tc.raw)
+		if (err != nil) != tc.fails {
+			t.Fatalf("'%s' - expected failure to be %t - got %v", tc.raw, tc.fails, err)
+		}
+
+		if number != tc.number || clock != tc.clock {
+			t.Fatalf("'%s' - expected %d %d - got %d %d", tc.raw, tc.number, tc.clock, number, clock)
+		}
+	}
+}
+
+// runLockOrderTest starts the specified number of test harness processes,
+// each of which locks the mutex the specified number of times. It
+// returns the PIDs of the processes in the order in which they locked
+// the mutex.
+func runLockOrderTest(env testEnv, processes int, iterations int, t *testing.T) []string {
+	orderFilePath := env.mutexConfig.Resource + ".order"
+
+	first := compileTestHarness(env, testHarnessOptions{
+		config:        env.mutexConfig,
+		orderFilePath: orderFilePath,
+		iterations:    iterations,
+	}, t)
+
+	harnesses := []*exec.Cmd{first}
+	for i := 1; i < processes; i++ {
+		harnesses = append(harnesses, exec.Command(first.Path, first.Args[1:]...))
+	}
+
+	for _, harness := range harnesses {
+		err := harness.Start()
+		if err != nil {
+			t.Fatalf("test harness failed to start - %s", err.Error())
+		}
+		defer harness.Process.Kill()
+	}
+
+	// Give the processes a chance to start waiting for the order file.
+	time.Sleep(500 * time.Millisecond)
+
+	err := ioutil.WriteFile(orderFilePath, nil, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	exited := make(chan error, processes)
+	for _, harness := range harnesses {
+		go func(harness *exec.Cmd) {
+			exited <- harness.Wait()
+		}(harness)
+	}
+
+	timeout := time.After(30 * time.Second)
+	for range harnesses {
+		select {
+		case err := <-exited:
+			if err != nil {
+				t.Fatalf("test harness failed - %s", err.Error())
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for test harnesses to exit")
+		}
+	}
+
+	raw, err := ioutil.ReadFile(orderFilePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	lines := strings.Fields(string(raw))
+	if len(lines) != processes*iterations {
+		t.Fatalf("expected %d entries in the order file - got %d", processes*iterations, len(lines))
+	}
+
+	return lines
+}
@@ -0,0 +1,37 @@
+// Package inspect publishes the state of the ipcm Mutexes in the current
+// process as JSON, for debugging purposes. Refer to ipcm.LiveMutexes for
+// more information.
+package inspect
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"github.com/stephen-fox/ipcm"
+)
+
+// Handler returns an http.Handler that responds with the state of every
+// live Mutex in the current process as a JSON array.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, err := json.MarshalIndent(ipcm.LiveMutexes(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(raw, '\n'))
+	})
+}
+
+// Publish publishes the state of every live Mutex in the current process
+// as an expvar variable with the specified name. The state is collected
+// each time the variable is read (e.g., when '/debug/vars' is requested).
+// Like expvar.Publish, it panics if the name is already in use.
+func Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return ipcm.LiveMutexes()
+	}))
+}
@@ -0,0 +1,109 @@
+package inspect
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stephen-fox/ipcm"
+)
+
+func newTestMutex(t *testing.T) ipcm.Mutex {
+	dir, err := ioutil.TempDir("", "ipcm-inspect-")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	m, err := ipcm.NewMutex(ipcm.MutexConfig{
+		Resource: path.Join(dir, "lock"),
+		Label:    "inspect-test",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return m
+}
+
+func TestHandler(t *testing.T) {
+	m := newTestMutex(t)
+	defer m.Close()
+
+	m.Lock()
+
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		err := m.TimedTryLock(500 * time.Millisecond)
+		if err == nil {
+			m.Unlock()
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/ipcm", nil))
+
+	var states []ipcm.MutexState
+	err := json.Unmarshal(recorder.Body.Bytes(), &states)
+	if err != nil {
+		t.Fatalf("failed to parse response - %s - %s", err.Error(), recorder.Body.String())
+	}
+
+	<-waiterDone
+	m.Unlock()
+
+	if len(states) != 1 {
+		t.Fatalf("expected one mutex - got %+v", states)
+	}
+
+	state := states[0]
+	if !state.Held || state.HeldSince == nil || state.HeldForSeconds <= 0 {
+		t.Fatalf("mutex should be reported as held - got %+v", state)
+	}
+
+	if state.Waiters != 1 {
+		t.Fatalf("expected one waiter - got %d", state.Waiters)
+	}
+
+	if state.Label != "inspect-test" || state.Backend != ipcm.DefaultBackend.String() {
+		t.Fatalf("unexpected configuration - got %+v", state)
+	}
+
+	m.Close()
+
+	if states := ipcm.LiveMutexes(); len(states) != 0 {
+		t.Fatalf("closed mutex should not be live - got %+v", states)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	m := newTestMutex(t)
+	defer m.Close()
+
+	Publish("ipcm_test")
+
+	v := expvar.Get("ipcm_test")
+	if v == nil {
+		t.Fatal("variable was not published")
+	}
+
+	var states []ipcm.MutexState
+	err := json.Unmarshal([]byte(v.String()), &states)
+	if err != nil {
+		t.Fatalf("failed to parse variable - %s - %s", err.Error(), v.String())
+	}
+
+	if len(states) != 1 || states[0].Held {
+		t.Fatalf("expected one unlocked mutex - got %+v", states)
+	}
+}
@@ -0,0 +1,350 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	leaseFileSuffix = ".lease"
+
+	minLeaseRenewInterval = time.Millisecond
+)
+
+var (
+	errLeaseLost = errors.New("the lease was lost")
+)
+
+// leaseRecord is the content of a lease file.
+type leaseRecord struct {
+	// ID uniquely identifies a single acquisition of the lease.
+	ID string `json:"id"`
+
+	// ExpiresAt is when the lease expires unless it is renewed.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// expired returns true if the lease expired at the specified time.
+func (o leaseRecord) expired(now time.Time) bool {
+	return !now.Before(o.ExpiresAt)
+}
+
+// leaseLocker is an osLocker that holds a lease rather than an OS lock.
+// The lease is stored in a file that is only read or modified while
+// the guard (the resource's lock file) is locked exclusively. While the
+// lease is held, a heartbeat routine renews it periodically.
+//
+// Like other osLocker implementations, it is not safe for concurrent
+// use, with the exception of lostChannel and guarded.
+type leaseLocker struct {
+	guardMutex    sync.Mutex
+	guard         *lockFile
+	path          string
+	ttl           time.Duration
+	renewInterval time.Duration
+	retry         RetryPolicy
+	id            string
+	stop          chan struct{}
+	done          chan struct{}
+	mutex         sync.Mutex
+	lost          chan struct{}
+}
+
+// newLeaseLocker creates a leaseLocker that uses the specified lockFile
+// as its guard.
+func newLeaseLocker(guard *lockFile, ttl time.Duration, retry RetryPolicy) *leaseLocker {
+	renewInterval := ttl / 3
+	if renewInterval < minLeaseRenewInterval {
+		renewInterval = minLeaseRenewInterval
+	}
+
+	return &leaseLocker{
+		guard:         guard,
+		path:          guard.path + leaseFileSuffix,
+		ttl:           ttl,
+		renewInterval: renewInterval,
+		retry:         retry,
+	}
+}
+
+func (o *leaseLocker) lock(ctx context.Context) error {
+	backoff := o.retry.newBackoff()
+
+	for {
+		record, acquired, err := o.tryAcquire(ctx)
+		if err != nil {
+			return err
+		}
+
+		if acquired {
+			lost := make(chan struct{})
+			o.mutex.Lock()
+			o.lost = lost
+			o.mutex.Unlock()
+
+			o.id = record.ID
+			o.stop = make(chan struct{})
+			o.done = make(chan struct{})
+			go o.heartbeat(record, lost, o.stop, o.done)
+
+			return nil
+		}
+
+		// Check back when the current lease expires, or sooner in
+		// case it is released.
+		err = backoff.waitAtMost(ctx, time.Until(record.ExpiresAt))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tryAcquire acquires the lease if it is not held, or if it expired.
+// If the lease is held by someone else, their leaseRecord is returned.
+func (o *leaseLocker) tryAcquire(ctx context.Context) (leaseRecord, bool, error) {
+	err := o.lockGuard(ctx)
+	if err != nil {
+		return leaseRecord{}, false, err
+	}
+	defer o.unlockGuard()
+
+	now := time.Now()
+
+	current, err := readLease(o.path)
+	if err == nil && !current.expired(now) {
+		return current, false, nil
+	}
+
+	record := leaseRecord{
+		ID:        newLeaseID(),
+		ExpiresAt: now.Add(o.ttl),
+	}
+
+	err = writeLease(o.path, record)
+	if err != nil {
+		// Try again later.
+		return leaseRecord{ExpiresAt: now}, false, nil
+	}
+
+	return record, true, nil
+}
+
+// heartbeat renews the lease until it is stopped. If the lease is taken
+// over by someone else, or if it expires before it can be renewed, the
+// lost channel is closed.
+func (o *leaseLocker) heartbeat(record leaseRecord, lost chan struct{}, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(o.renewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		renewed, err := o.renew(&record)
+		if err == nil && !renewed {
+			close(lost)
+			return
+		}
+
+		if err != nil && record.expired(time.Now()) {
+			close(lost)
+			return
+		}
+	}
+}
+
+// renew extends the lease described by the leaseRecord. It returns
+// false if the lease is no longer held by the leaseRecord's owner.
+func (o *leaseLocker) renew(record *leaseRecord) (bool, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), record.ExpiresAt)
+	defer cancel()
+
+	err := o.lockGuard(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer o.unlockGuard()
+
+	current, err := readLease(o.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Someone else took over the lease and released it.
+			return false, nil
+		}
+		return false, err
+	}
+
+	if current.ID != record.ID {
+		return false, nil
+	}
+
+	renewed := leaseRecord{
+		ID:        record.ID,
+		ExpiresAt: time.Now().Add(o.ttl),
+	}
+
+	err = writeLease(o.path, renewed)
+	if err != nil {
+		return false, err
+	}
+
+	*record = renewed
+
+	return true, nil
+}
+
+// unlock stops renewing the lease and releases it, unless someone else
+// took it over in the meantime.
+func (o *leaseLocker) unlock() error {
+	close(o.stop)
+	<-o.done
+
+	o.mutex.Lock()
+	o.lost = nil
+	o.mutex.Unlock()
+
+	// If the guard cannot be locked, the lease will expire on its own.
+	ctx, cancel := context.WithTimeout(context.Background(), o.ttl)
+	defer cancel()
+
+	err := o.lockGuard(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.unlockGuard()
+
+	current, err := readLease(o.path)
+	if err == nil && current.ID == o.id {
+		return os.Remove(o.path)
+	}
+
+	return nil
+}
+
+func (o *leaseLocker) close(remove bool) error {
+	o.guardMutex.Lock()
+	defer o.guardMutex.Unlock()
+
+	return o.guard.close(remove)
+}
+
+// guarded calls fn while the guard is locked, provided that the lease
+// is still held. It is used to update other files that must only be
+// modified by the lease's holder.
+func (o *leaseLocker) guarded(fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), o.ttl)
+	defer cancel()
+
+	err := o.lockGuard(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.unlockGuard()
+
+	current, err := readLease(o.path)
+	if err != nil {
+		return err
+	}
+
+	if current.ID != o.id || current.expired(time.Now()) {
+		return errLeaseLost
+	}
+
+	return fn()
+}
+
+// lockGuard locks the guard exclusively. The guard is shared by the
+// caller of the osLocker methods and the heartbeat routine, which is
+// why access to it is serialized.
+func (o *leaseLocker) lockGuard(ctx context.Context) error {
+	o.guardMutex.Lock()
+
+	err := o.guard.lock(ctx, unix.LOCK_EX)
+	if err != nil {
+		o.guardMutex.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (o *leaseLocker) unlockGuard() {
+	o.guard.unlock()
+	o.guardMutex.Unlock()
+}
+
+// lostChannel returns the channel that is closed if the current lease
+// is lost. It returns nil if the lease is not held.
+func (o *leaseLocker) lostChannel() <-chan struct{} {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.lost
+}
+
+// isLeaseHeld returns true if the resource's lease is held and has not
+// expired.
+func isLeaseHeld(resource string) (bool, error) {
+	record, err := readLease(resource + leaseFileSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return !record.expired(time.Now()), nil
+}
+
+func readLease(leasePath string) (leaseRecord, error) {
+	raw, err := ioutil.ReadFile(leasePath)
+	if err != nil {
+		return leaseRecord{}, err
+	}
+
+	var record leaseRecord
+	err = json.Unmarshal(raw, &record)
+	if err != nil {
+		return leaseRecord{}, err
+	}
+
+	return record, nil
+}
+
+func writeLease(leasePath string, record leaseRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return replaceFile(leasePath, raw)
+}
+
+// newLeaseID returns a random ID for a lease acquisition.
+func newLeaseID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// Fall back to something that is unique within the host.
+		return strconv.Itoa(os.Getpid()) + "-" + time.Now().Format(time.RFC3339Nano)
+	}
+
+	return hex.EncodeToString(b)
+}
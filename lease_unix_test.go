@@ -0,0 +1,203 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMutex_Lease(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.LeaseTTL = 300 * time.Millisecond
+
+	a, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	b, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	if a.Lost() != nil {
+		t.Fatal("lost channel should be nil while the mutex is unlocked")
+	}
+
+	a.Lock()
+
+	// The lease must be renewed while it is held.
+	time.Sleep(3 * env.mutexConfig.LeaseTTL)
+
+	err = b.TimedTryLock(100 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while the lease is held")
+	}
+
+	if isClosed(a.Lost()) {
+		t.Fatal("lease should not have been lost")
+	}
+
+	info, err := Owner(env.mutexConfig)
+	if err != nil {
+		t.Fatalf("failed to get owner - %s", err.Error())
+	}
+
+	if info.PID != os.Getpid() {
+		t.Fatalf("owner pid should be %d - got %d", os.Getpid(), info.PID)
+	}
+
+	a.Unlock()
+
+	err = b.TimedTryLock(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the lease was released - %s", err.Error())
+	}
+
+	b.Unlock()
+
+	_, err = os.Stat(env.mutexConfig.Resource + leaseFileSuffix)
+	if !os.IsNotExist(err) {
+		t.Fatalf("lease file should have been removed - got %v", err)
+	}
+}
+
+func TestMutex_LeaseLost(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.LeaseTTL = 300 * time.Millisecond
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.Lock()
+
+	err = writeLease(env.mutexConfig.Resource+leaseFileSuffix, leaseRecord{
+		ID:        "someone else",
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	select {
+	case <-m.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost channel should have been closed once the lease was taken over")
+	}
+
+	record, err := readLease(env.mutexConfig.Resource + leaseFileSuffix)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if record.ID != "someone else" {
+		t.Fatalf("lease should not have been renewed after it was lost - got id '%s'", record.ID)
+	}
+
+	err = m.UnlockErr()
+	unlockErr, ok := err.(*UnlockError)
+	if !ok || !unlockErr.LeaseLost() {
+		t.Fatalf("unlocking a mutex whose lease was lost should fail with a lease lost error - got %v", err)
+	}
+
+	_, err = os.Stat(env.mutexConfig.Resource + leaseFileSuffix)
+	if err != nil {
+		t.Fatalf("the lease file of the new holder should not have been removed - %s", err.Error())
+	}
+}
+
+func TestMutex_LeaseTakeover(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.LeaseTTL = 500 * time.Millisecond
+
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	err = m.TimedTryLock(2 * env.mutexConfig.LeaseTTL)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while another process holds the lease")
+	}
+
+	// Simulate a hung holder. The holder must not be stopped while
+	// it is renewing the lease, because it holds the lease's guard
+	// while doing so.
+	for {
+		err = testHarness.Process.Signal(syscall.SIGSTOP)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		held, err := isLockFileHeld(env.mutexConfig.Resource, flockMethod)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if !held {
+			break
+		}
+
+		testHarness.Process.Signal(syscall.SIGCONT)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	err = m.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the lease expired - %s", err.Error())
+	}
+	defer m.Unlock()
+
+	err = testHarness.Process.Signal(syscall.SIGCONT)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	exited := make(chan struct{})
+	go func() {
+		testHarness.Wait()
+		close(exited)
+	}()
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("test harness should have exited after losing its lease")
+	}
+
+	output := testHarness.Stdout.(*bytes.Buffer).String()
+	if !strings.Contains(output, "lost") {
+		t.Fatalf("test harness should have reported that its lease was lost - output: %s", output)
+	}
+
+	if isClosed(m.Lost()) {
+		t.Fatal("lease should not have been lost by the new holder")
+	}
+}
+
+func TestNewMutex_NegativeLeaseTTL(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.LeaseTTL = -time.Second
+
+	_, err := NewMutex(env.mutexConfig)
+	if _, ok := err.(*ConfigureError); !ok {
+		t.Fatalf("expected a *ConfigureError - got %v", err)
+	}
+}
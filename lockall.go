@@ -0,0 +1,122 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// orderedMutex is implemented by Mutex implementations that can be
+// locked by LockAll. The lock order key identifies the OS mutex. Mutexes
+// with the same key reference the same OS mutex.
+type orderedMutex interface {
+	lockOrderKey() string
+}
+
+// lockOrderKey returns the key used to order the OS mutex referenced by
+// the MutexConfig.
+func lockOrderKey(config MutexConfig) string {
+	return config.Resource + "\x00" + config.Backend.String()
+}
+
+// LockAll locks all of the specified mutexes. The mutexes are locked in
+// a canonical order (sorted by resource) regardless of the order in
+// which they are specified. This prevents deadlocks between routines
+// (in any process) that lock overlapping sets of mutexes using LockAll.
+//
+// If the context is done, or a Mutex cannot be locked, the mutexes that
+// were already locked are unlocked and the error is returned.
+//
+// A *LockError is returned if the same OS mutex is specified more than
+// once, or if a Mutex was not created by this package.
+func LockAll(ctx context.Context, mutexes ...Mutex) error {
+	return lockAll(mutexes, func(m Mutex) error {
+		return m.LockContext(ctx)
+	})
+}
+
+// TimedTryLockAll is like LockAll, but gives up once the specified
+// timeout is exceeded. The timeout applies to locking all of the
+// mutexes, not to each one of them.
+func TimedTryLockAll(timeout time.Duration, mutexes ...Mutex) error {
+	deadline := time.Now().Add(timeout)
+
+	return lockAll(mutexes, func(m Mutex) error {
+		remaining := time.Until(deadline)
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		return m.TimedTryLock(remaining)
+	})
+}
+
+// UnlockAll unlocks all of the specified mutexes in the reverse of the
+// order in which LockAll locks them.
+func UnlockAll(mutexes ...Mutex) {
+	sorted, err := sortMutexes(mutexes)
+	if err != nil {
+		sorted = mutexes
+	}
+
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i].Unlock()
+	}
+}
+
+func lockAll(mutexes []Mutex, lock func(Mutex) error) error {
+	sorted, err := sortMutexes(mutexes)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range sorted {
+		err := lock(m)
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				sorted[j].Unlock()
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortMutexes returns a copy of the mutexes sorted in their canonical
+// lock order.
+func sortMutexes(mutexes []Mutex) ([]Mutex, error) {
+	keys := make(map[Mutex]string, len(mutexes))
+	seen := make(map[string]bool, len(mutexes))
+
+	for _, m := range mutexes {
+		ordered, ok := m.(orderedMutex)
+		if !ok {
+			return nil, &LockError{
+				reason: fmt.Sprintf("%s the lock order of a %T cannot be determined",
+					unableToAcquirePrefix, m),
+			}
+		}
+
+		key := ordered.lockOrderKey()
+		if seen[key] {
+			return nil, &LockError{
+				reason: fmt.Sprintf("%s the same resource was specified more than once",
+					unableToAcquirePrefix),
+			}
+		}
+
+		seen[key] = true
+		keys[m] = key
+	}
+
+	sorted := make([]Mutex, len(mutexes))
+	copy(sorted, mutexes)
+
+	sort.Slice(sorted, func(i int, j int) bool {
+		return keys[sorted[i]] < keys[sorted[j]]
+	})
+
+	return sorted, nil
+}
@@ -0,0 +1,102 @@
+package ipcm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestMutexes creates a Mutex for each of the specified resources.
+func newTestMutexes(env testEnv, resources []string, t *testing.T) []Mutex {
+	var mutexes []Mutex
+
+	for _, resource := range resources {
+		config := env.mutexConfig
+		config.Resource = resource
+
+		m, err := NewMutex(config)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		mutexes = append(mutexes, m)
+	}
+
+	return mutexes
+}
+
+func TestLockAll_OppositeOrders(t *testing.T) {
+	env := setupTestEnv(t)
+	resources := []string{env.mutexConfig.Resource + "-a", env.mutexConfig.Resource + "-b"}
+
+	forward := newTestMutexes(env, resources, t)
+	backward := newTestMutexes(env, []string{resources[1], resources[0]}, t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	for _, mutexes := range [][]Mutex{forward, backward} {
+		wg.Add(1)
+		go func(mutexes []Mutex) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				err := LockAll(ctx, mutexes...)
+				if err != nil {
+					t.Errorf("failed to lock all mutexes - %s", err.Error())
+					return
+				}
+
+				UnlockAll(mutexes...)
+			}
+		}(mutexes)
+	}
+
+	wg.Wait()
+}
+
+func TestTimedTryLockAll_RollsBack(t *testing.T) {
+	env := setupTestEnv(t)
+	resources := []string{env.mutexConfig.Resource + "-a", env.mutexConfig.Resource + "-b"}
+
+	mutexes := newTestMutexes(env, resources, t)
+	others := newTestMutexes(env, resources, t)
+
+	// Hold the mutex that is locked last.
+	err := others[1].TimedTryLock(time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = TimedTryLockAll(200*time.Millisecond, mutexes...)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while one of the mutexes is held")
+	}
+
+	err = others[0].TimedTryLock(100 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("the mutex locked before the failure should have been unlocked - %s", err.Error())
+	}
+
+	UnlockAll(others...)
+
+	err = TimedTryLockAll(time.Second, mutexes...)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the mutexes were released - %s", err.Error())
+	}
+
+	UnlockAll(mutexes...)
+}
+
+func TestLockAll_SameResource(t *testing.T) {
+	env := setupTestEnv(t)
+
+	mutexes := newTestMutexes(env, []string{env.mutexConfig.Resource, env.mutexConfig.Resource}, t)
+
+	err := LockAll(context.Background(), mutexes...)
+	if _, ok := err.(*LockError); !ok {
+		t.Fatalf("expected a *LockError when the same resource is specified twice - got %v", err)
+	}
+}
@@ -0,0 +1,468 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	dirMode  = 0755
+	lockMode = 0644
+)
+
+// lockMethod is a system call used to lock a file.
+type lockMethod int
+
+const (
+	// flockMethod locks files using flock(2).
+	flockMethod lockMethod = iota
+
+	// ofdMethod locks files using open file description (OFD) locks,
+	// i.e., fcntl(2) with F_OFD_SETLK and F_OFD_SETLKW. Like flock(2)
+	// locks, OFD locks are owned by the open file description rather
+	// than the process. OFD locks are only available on Linux.
+	ofdMethod
+)
+
+// apply applies a flock(2) style lock operation (unix.LOCK_SH,
+// unix.LOCK_EX or unix.LOCK_UN, optionally combined with unix.LOCK_NB)
+// to the file descriptor. unix.EWOULDBLOCK is returned if a non-blocking
+// operation conflicts with another lock.
+func (o lockMethod) apply(fd int, how int) error {
+	switch o {
+	case ofdMethod:
+		return ofdLock(fd, how, 0, 0)
+	default:
+		return unix.Flock(fd, how)
+	}
+}
+
+// openFlag returns the flag needed to open a file that is locked using
+// the lockMethod. Exclusive OFD locks require the file to be writable.
+func (o lockMethod) openFlag() int {
+	switch o {
+	case ofdMethod:
+		return os.O_RDWR
+	default:
+		return os.O_RDONLY
+	}
+}
+
+// lockFile is a file that is locked using a lockMethod (flock(2) unless
+// specified otherwise). It is not safe for concurrent use - callers are
+// expected to serialize access to it.
+type lockFile struct {
+	path   string
+	method lockMethod
+	retry  RetryPolicy
+	shared bool
+	file   *os.File
+	waiter *lockWaiter
+}
+
+// lock locks the file using the specified lock operation (either
+// unix.LOCK_SH or unix.LOCK_EX). If the file is locked by another
+// process, a blocking call is used so that the kernel hands over the
+// lock as soon as it is released.
+//
+// Failures are retried according to the lockFile's RetryPolicy until
+// the context is done, at which point ctx.Err() is returned.
+func (o *lockFile) lock(ctx context.Context, how int) error {
+	backoff := o.retry.newBackoff()
+
+	for {
+		if o.waiter != nil && !o.waiter.adopt(how) {
+			if o.waiter.detach() {
+				// The waiter now owns the file.
+				o.file = nil
+			}
+			o.waiter = nil
+		}
+
+		if o.waiter == nil {
+			err := o.openUnsafe()
+			if err != nil {
+				if sleepErr := backoff.wait(ctx); sleepErr != nil {
+					return sleepErr
+				}
+				continue
+			}
+
+			lockErr := o.method.apply(int(o.file.Fd()), how|unix.LOCK_NB)
+			if lockErr == nil {
+				if o.lockedCurrentUnsafe() {
+					return nil
+				}
+				continue
+			}
+
+			if lockErr != unix.EWOULDBLOCK {
+				if sleepErr := backoff.wait(ctx); sleepErr != nil {
+					return sleepErr
+				}
+				continue
+			}
+
+			if ctx.Err() != nil {
+				// No point in waiting for the lock.
+				return ctx.Err()
+			}
+
+			fd := int(o.file.Fd())
+			method := o.method
+			o.waiter = startLockWaiter(o.file, func(how int) error {
+				return method.apply(fd, how)
+			}, how)
+		}
+
+		err := o.waiter.wait(ctx)
+		if err == ctx.Err() && err != nil {
+			// The abandoned waiter is kept around so that the
+			// next lock attempt can adopt it.
+			return err
+		}
+
+		o.waiter = nil
+
+		if err == nil {
+			if o.lockedCurrentUnsafe() {
+				return nil
+			}
+			continue
+		}
+
+		if sleepErr := backoff.wait(ctx); sleepErr != nil {
+			return sleepErr
+		}
+	}
+}
+
+// tryLock attempts to lock the file using the specified lock operation
+// without blocking. It returns false if the file is locked
+// by another process.
+//
+// The file must already be open. This allows an existing lock to be
+// converted (e.g., from shared to exclusive). Be advised that converting
+// a lock is not atomic. If the conversion fails, the existing lock is
+// lost.
+func (o *lockFile) tryLock(how int) (bool, error) {
+	if o.file == nil {
+		return false, os.ErrInvalid
+	}
+
+	err := o.method.apply(int(o.file.Fd()), how|unix.LOCK_NB)
+	switch err {
+	case nil:
+		return true, nil
+	case unix.EWOULDBLOCK:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// unlock unlocks the file. If the file cannot be unlocked, it is closed
+// instead, which releases the lock as well. The file is reopened by the
+// next lock attempt.
+func (o *lockFile) unlock() error {
+	if o.file == nil {
+		return nil
+	}
+
+	err := o.method.apply(int(o.file.Fd()), unix.LOCK_UN)
+	if err != nil {
+		o.file.Close()
+		o.file = nil
+		return err
+	}
+
+	return nil
+}
+
+// close closes the lock file. The file must be unlocked. If remove is
+// true, the file is removed as well, but only if no other process holds
+// it. The file is locked exclusively while it is removed so that
+// processes waiting for it notice it was removed once they lock it.
+func (o *lockFile) close(remove bool) error {
+	if o.waiter != nil {
+		if o.waiter.detach() {
+			o.file = nil
+		}
+		o.waiter = nil
+	}
+
+	if o.file == nil {
+		return nil
+	}
+
+	if remove && o.isCurrentUnsafe() {
+		locked, _ := o.tryLock(unix.LOCK_EX)
+		if locked {
+			os.Remove(o.path)
+		}
+	}
+
+	err := o.file.Close()
+	o.file = nil
+
+	return err
+}
+
+// lockedCurrentUnsafe is called after the file has been locked. It
+// returns true if the lock file's path still refers to the locked file.
+//
+// Another process may have deleted or replaced the lock file while this
+// one was waiting for it. In that case, the lock is worthless because
+// other processes will lock the file that now exists at the path. The
+// stale file is unlocked and closed, and false is returned.
+func (o *lockFile) lockedCurrentUnsafe() bool {
+	if o.isCurrentUnsafe() {
+		return true
+	}
+
+	o.method.apply(int(o.file.Fd()), unix.LOCK_UN)
+	o.file.Close()
+	o.file = nil
+
+	return false
+}
+
+// isCurrentUnsafe returns true if the lock file's path refers to the
+// same device and inode as the open file.
+func (o *lockFile) isCurrentUnsafe() bool {
+	if o.file == nil {
+		return false
+	}
+
+	fileInfo, err := o.file.Stat()
+	if err != nil {
+		return false
+	}
+
+	pathInfo, err := os.Stat(o.path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(fileInfo, pathInfo)
+}
+
+// openUnsafe opens the lock file (creating it and its parent directory
+// if needed) if it is not already open, or if the lock file's path no
+// longer refers to the open file.
+func (o *lockFile) openUnsafe() error {
+	if o.isCurrentUnsafe() {
+		return nil
+	}
+
+	return o.resetUnsafe()
+}
+
+// resetUnsafe (re)opens the lock file, creating it and its parent
+// directory if needed.
+func (o *lockFile) resetUnsafe() error {
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+
+	err := os.MkdirAll(path.Dir(o.path), dirMode)
+	if err != nil {
+		return &LockError{
+			reason:  fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
+			dirFail: true,
+		}
+	}
+
+	if o.shared {
+		o.file, err = openSharedFile(o.path, o.method.openFlag())
+	} else {
+		o.file, err = os.OpenFile(o.path, o.method.openFlag()|os.O_CREATE, lockMode)
+	}
+	if err != nil {
+		return &LockError{
+			reason:     fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
+			createFail: true,
+		}
+	}
+
+	return nil
+}
+
+// openSharedFile opens a file in a directory that is shared by all users
+// (refer to SystemScope), creating it if needed. Symbolic links are not
+// followed, so that other users cannot redirect the file to a file of
+// the current user. A file created by the current process is made
+// readable and writable by all users, so that processes run by other
+// users can lock it regardless of the lock method. An existing file is
+// left as is, and must be a regular file.
+func openSharedFile(filePath string, flag int) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(filePath, flag|os.O_CREATE|os.O_EXCL|unix.O_NOFOLLOW, sharedLockMode)
+		if err == nil {
+			// The mode passed to open(2) is subject to the umask.
+			err = file.Chmod(sharedLockMode)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+
+			return file, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		file, err = os.OpenFile(filePath, flag|unix.O_NOFOLLOW, 0)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The file was removed in the meantime.
+				continue
+			}
+			return nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		if !info.Mode().IsRegular() {
+			file.Close()
+			return nil, fmt.Errorf("'%s' is not a regular file", filePath)
+		}
+
+		return file, nil
+	}
+}
+
+// lockWaiter performs a blocking lock call in a separate routine.
+// This allows the caller to stop waiting for the lock (for example, when
+// a context is cancelled) while still having the kernel wake the waiter
+// as soon as the lock is released.
+//
+// A blocking lock call cannot be interrupted. When the caller gives
+// up, the waiter is abandoned. An abandoned waiter that goes on to lock
+// the file unlocks it immediately. An abandoned waiter can be adopted
+// by a subsequent lock attempt, which avoids piling up blocked system
+// calls when lock attempts are repeatedly abandoned. If it cannot be
+// adopted, it can be detached instead, in which case it closes the file
+// once the lock call returns.
+type lockWaiter struct {
+	file      *os.File
+	apply     func(how int) error
+	how       int
+	mutex     sync.Mutex
+	abandoned bool
+	detached  bool
+	finished  bool
+	result    chan error
+}
+
+// startLockWaiter starts waiting on a blocking lock call for the
+// specified file. The apply function applies a flock(2) style lock
+// operation to the file.
+func startLockWaiter(file *os.File, apply func(how int) error, how int) *lockWaiter {
+	w := &lockWaiter{
+		file:   file,
+		apply:  apply,
+		how:    how,
+		result: make(chan error, 1),
+	}
+
+	go w.run()
+
+	return w
+}
+
+func (o *lockWaiter) run() {
+	var err error
+	for {
+		err = o.apply(o.how)
+		if err != unix.EINTR {
+			break
+		}
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.finished = true
+
+	if o.abandoned {
+		if err == nil {
+			o.apply(unix.LOCK_UN)
+		}
+		if o.detached {
+			o.file.Close()
+		}
+		return
+	}
+
+	o.result <- err
+}
+
+// wait waits for the lock call to complete and returns its result.
+// If the context is done first, the waiter is abandoned and ctx.Err()
+// is returned.
+func (o *lockWaiter) wait(ctx context.Context) error {
+	select {
+	case err := <-o.result:
+		return err
+	case <-ctx.Done():
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	select {
+	case err := <-o.result:
+		// The call completed while the context was being cancelled.
+		return err
+	default:
+		o.abandoned = true
+		return ctx.Err()
+	}
+}
+
+// adopt reclaims an abandoned waiter that is waiting for the specified
+// lock operation. It returns false if the waiter is waiting for
+// a different operation, or if it already finished (in which case it
+// no longer holds, or waits for, the lock).
+func (o *lockWaiter) adopt(how int) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.finished || o.how != how {
+		return false
+	}
+
+	o.abandoned = false
+
+	return true
+}
+
+// detach hands ownership of the file to an abandoned waiter. It returns
+// false if the waiter already finished, in which case the caller keeps
+// ownership of the file.
+func (o *lockWaiter) detach() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.finished {
+		return false
+	}
+
+	o.detached = true
+
+	return true
+}
@@ -0,0 +1,348 @@
+// Package metrics collects ipcm lock statistics and exports them in the
+// Prometheus text exposition format, without depending on the Prometheus
+// client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stephen-fox/ipcm"
+)
+
+const (
+	configureErrPrefix = "failed to configure collector -"
+
+	defaultNamespace = "ipcm"
+
+	contentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// DefaultBuckets are the default upper bounds, in seconds, of the wait
+// and hold time histogram buckets.
+var DefaultBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// CollectorConfig configures a Collector.
+type CollectorConfig struct {
+	// Namespace is the prefix of the metric names. The zero value
+	// is 'ipcm'.
+	Namespace string
+
+	// Buckets are the upper bounds, in seconds, of the wait and hold
+	// time histogram buckets. They must be sorted in increasing order.
+	// The zero value is DefaultBuckets.
+	Buckets []float64
+}
+
+// ConfigureError describes a problem with a CollectorConfig.
+type ConfigureError struct {
+	reason string
+}
+
+func (o *ConfigureError) Error() string {
+	return o.reason
+}
+
+// Collector is an ipcm.Observer that collects per-resource lock
+// statistics. Set it as the MutexConfig.Observer of every Mutex that
+// should be monitored. A single Collector can be shared by any number
+// of Mutexes.
+//
+// It is also an http.Handler that serves the statistics in the
+// Prometheus text exposition format. The following metrics are
+// exported, labeled by resource:
+//
+//	<namespace>_lock_attempts_total      counter
+//	<namespace>_lock_acquisitions_total  counter
+//	<namespace>_lock_retries_total       counter
+//	<namespace>_lock_timeouts_total      counter
+//	<namespace>_lock_wait_seconds        histogram
+//	<namespace>_lock_hold_seconds        histogram
+//
+// Timeouts are also labeled by the mutex that the lock attempt timed out
+// on - 'sync' for the in-process mutex, or 'system' for the OS mutex.
+type Collector interface {
+	ipcm.Observer
+	http.Handler
+
+	// WriteTo writes the statistics to the io.Writer in the Prometheus
+	// text exposition format.
+	WriteTo(w io.Writer) (int64, error)
+}
+
+type resourceStats struct {
+	attempts       uint64
+	acquisitions   uint64
+	syncTimeouts   uint64
+	systemTimeouts uint64
+	retries        uint64
+	wait           histogram
+	hold           histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (o *histogram) observe(buckets []float64, duration time.Duration) {
+	if o.counts == nil {
+		o.counts = make([]uint64, len(buckets))
+	}
+
+	seconds := duration.Seconds()
+
+	for i, bound := range buckets {
+		if seconds <= bound {
+			o.counts[i]++
+		}
+	}
+
+	o.count++
+	o.sum += seconds
+}
+
+type collector struct {
+	mutex     sync.Mutex
+	namespace string
+	buckets   []float64
+	resources map[string]*resourceStats
+}
+
+func (o *collector) statsUnsafe(resource string) *resourceStats {
+	stats, ok := o.resources[resource]
+	if !ok {
+		stats = &resourceStats{}
+		o.resources[resource] = stats
+	}
+
+	return stats
+}
+
+func (o *collector) LockStarted(resource string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.statsUnsafe(resource).attempts++
+}
+
+func (o *collector) LockAcquired(resource string, wait time.Duration, retries int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	stats := o.statsUnsafe(resource)
+	stats.acquisitions++
+	stats.retries += uint64(retries)
+	stats.wait.observe(o.buckets, wait)
+}
+
+func (o *collector) LockTimedOut(resource string, wait time.Duration, system bool) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	stats := o.statsUnsafe(resource)
+	if system {
+		stats.systemTimeouts++
+	} else {
+		stats.syncTimeouts++
+	}
+}
+
+func (o *collector) Unlocked(resource string, hold time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.statsUnsafe(resource).hold.observe(o.buckets, hold)
+}
+
+func (o *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	o.WriteTo(w)
+}
+
+func (o *collector) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+
+	o.mutex.Lock()
+	o.writeUnsafe(buffered)
+	o.mutex.Unlock()
+
+	err := buffered.Flush()
+
+	return counter.n, err
+}
+
+func (o *collector) writeUnsafe(w io.Writer) {
+	resources := make([]string, 0, len(o.resources))
+	for resource := range o.resources {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(*resourceStats) uint64
+	}{
+		{
+			name:  "lock_attempts_total",
+			help:  "Number of attempts to lock the mutex.",
+			value: func(s *resourceStats) uint64 { return s.attempts },
+		},
+		{
+			name:  "lock_acquisitions_total",
+			help:  "Number of times the mutex was locked.",
+			value: func(s *resourceStats) uint64 { return s.acquisitions },
+		},
+		{
+			name:  "lock_retries_total",
+			help:  "Number of failed OS mutex lock attempts that were retried.",
+			value: func(s *resourceStats) uint64 { return s.retries },
+		},
+	}
+
+	for _, c := range counters {
+		name := o.namespace + "_" + c.name
+		writeHeader(w, name, c.help, "counter")
+
+		for _, resource := range resources {
+			fmt.Fprintf(w, "%s{resource=\"%s\"} %d\n",
+				name, escapeLabelValue(resource), c.value(o.resources[resource]))
+		}
+	}
+
+	name := o.namespace + "_lock_timeouts_total"
+	writeHeader(w, name, "Number of lock attempts that timed out.", "counter")
+	for _, resource := range resources {
+		stats := o.resources[resource]
+		label := escapeLabelValue(resource)
+		fmt.Fprintf(w, "%s{resource=\"%s\",mutex=\"sync\"} %d\n", name, label, stats.syncTimeouts)
+		fmt.Fprintf(w, "%s{resource=\"%s\",mutex=\"system\"} %d\n", name, label, stats.systemTimeouts)
+	}
+
+	name = o.namespace + "_lock_wait_seconds"
+	writeHeader(w, name, "Time spent waiting to lock the mutex.", "histogram")
+	for _, resource := range resources {
+		o.writeHistogram(w, name, resource, o.resources[resource].wait)
+	}
+
+	name = o.namespace + "_lock_hold_seconds"
+	writeHeader(w, name, "Time the mutex was held for.", "histogram")
+	for _, resource := range resources {
+		o.writeHistogram(w, name, resource, o.resources[resource].hold)
+	}
+}
+
+func (o *collector) writeHistogram(w io.Writer, name string, resource string, h histogram) {
+	label := escapeLabelValue(resource)
+
+	for i, bound := range o.buckets {
+		var count uint64
+		if h.counts != nil {
+			count = h.counts[i]
+		}
+
+		fmt.Fprintf(w, "%s_bucket{resource=\"%s\",le=\"%s\"} %d\n",
+			name, label, formatFloat(bound), count)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{resource=\"%s\",le=\"+Inf\"} %d\n", name, label, h.count)
+	fmt.Fprintf(w, "%s_sum{resource=\"%s\"} %s\n", name, label, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{resource=\"%s\"} %d\n", name, label, h.count)
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// escapeLabelValue escapes a label value as required by the text
+// exposition format.
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// countingWriter counts the number of bytes written to the underlying
+// io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (o *countingWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	o.n += int64(n)
+	return n, err
+}
+
+// NewCollector creates a new Collector.
+func NewCollector(config CollectorConfig) (Collector, error) {
+	namespace := config.Namespace
+	if len(namespace) == 0 {
+		namespace = defaultNamespace
+	}
+
+	if !isValidMetricName(namespace) {
+		return nil, &ConfigureError{
+			reason: fmt.Sprintf("%s the namespace is not a valid metric name - '%s'",
+				configureErrPrefix, namespace),
+		}
+	}
+
+	buckets := config.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	for i, bound := range buckets {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) || (i > 0 && bound <= buckets[i-1]) {
+			return nil, &ConfigureError{
+				reason: fmt.Sprintf("%s the buckets must be finite and sorted in increasing order - %v",
+					configureErrPrefix, buckets),
+			}
+		}
+	}
+
+	return &collector{
+		namespace: namespace,
+		buckets:   append([]float64(nil), buckets...),
+		resources: make(map[string]*resourceStats),
+	}, nil
+}
+
+// isValidMetricName returns true if the name matches the regular
+// expression [a-zA-Z_:][a-zA-Z0-9_:]*.
+func isValidMetricName(name string) bool {
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':':
+		case r >= 'a' && r <= 'z':
+		case r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+
+	return len(name) > 0
+}
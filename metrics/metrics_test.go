@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector_ServeHTTP(t *testing.T) {
+	c, err := NewCollector(CollectorConfig{
+		Buckets: []float64{0.1, 1},
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	c.LockStarted("/tmp/a")
+	c.LockAcquired("/tmp/a", 50*time.Millisecond, 2)
+	c.Unlocked("/tmp/a", 2*time.Second)
+	c.LockStarted("/tmp/a")
+	c.LockTimedOut("/tmp/a", time.Second, true)
+	c.LockStarted(`say "hi"`)
+	c.LockTimedOut(`say "hi"`, time.Second, false)
+
+	recorder := httptest.NewRecorder()
+	c.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("unexpected content type - got '%s'", contentType)
+	}
+
+	raw, err := ioutil.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []string{
+		"# TYPE ipcm_lock_attempts_total counter",
+		`ipcm_lock_attempts_total{resource="/tmp/a"} 2`,
+		`ipcm_lock_acquisitions_total{resource="/tmp/a"} 1`,
+		`ipcm_lock_retries_total{resource="/tmp/a"} 2`,
+		`ipcm_lock_timeouts_total{resource="/tmp/a",mutex="sync"} 0`,
+		`ipcm_lock_timeouts_total{resource="/tmp/a",mutex="system"} 1`,
+		`ipcm_lock_timeouts_total{resource="say \"hi\"",mutex="sync"} 1`,
+		"# TYPE ipcm_lock_wait_seconds histogram",
+		`ipcm_lock_wait_seconds_bucket{resource="/tmp/a",le="0.1"} 1`,
+		`ipcm_lock_wait_seconds_bucket{resource="/tmp/a",le="1"} 1`,
+		`ipcm_lock_wait_seconds_bucket{resource="/tmp/a",le="+Inf"} 1`,
+		`ipcm_lock_wait_seconds_sum{resource="/tmp/a"} 0.05`,
+		`ipcm_lock_wait_seconds_count{resource="/tmp/a"} 1`,
+		`ipcm_lock_hold_seconds_bucket{resource="/tmp/a",le="1"} 0`,
+		`ipcm_lock_hold_seconds_bucket{resource="/tmp/a",le="+Inf"} 1`,
+		`ipcm_lock_hold_seconds_sum{resource="/tmp/a"} 2`,
+		`ipcm_lock_hold_seconds_count{resource="say \"hi\""} 0`,
+	}
+
+	lines := make(map[string]bool)
+	for _, line := range strings.Split(string(raw), "\n") {
+		lines[line] = true
+	}
+
+	for _, line := range expected {
+		if !lines[line] {
+			t.Errorf("output is missing line '%s'", line)
+		}
+	}
+
+	if t.Failed() {
+		t.Logf("output:\n%s", raw)
+	}
+}
+
+func TestNewCollector_InvalidConfig(t *testing.T) {
+	configs := []CollectorConfig{
+		{Namespace: "0ipcm"},
+		{Namespace: "ipcm-locks"},
+		{Buckets: []float64{1, 0.5}},
+		{Buckets: []float64{1, 1}},
+	}
+
+	for _, config := range configs {
+		_, err := NewCollector(config)
+		if _, ok := err.(*ConfigureError); !ok {
+			t.Errorf("expected a configure error for %+v - got %v", config, err)
+		}
+	}
+}
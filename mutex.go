@@ -1,9 +1,9 @@
 package ipcm
 
 import (
+	"context"
 	"fmt"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -11,9 +11,12 @@ const (
 	configureErrPrefix    = "failed to configure mutex -"
 	unableToCreatePrefix  = "failed to create mutex -"
 	unableToAcquirePrefix = "failed to acquire mutex -"
+	queryErrPrefix        = "failed to query mutex -"
+	closeErrPrefix        = "failed to close mutex -"
+	unlockErrPrefix       = "failed to release mutex -"
 	exceededOsLockTimeout = unableToAcquirePrefix + " exceeded wait timeout of %s while waiting for OS mutex"
 
-	infiniteOsMutexLockTimeout time.Duration = -1
+	osMutexRetryInterval = 100 * time.Millisecond
 )
 
 // MutexConfig configures a Mutex.
@@ -33,6 +36,188 @@ type MutexConfig struct {
 	// For example:
 	//  myapplication
 	Resource string
+
+	// Label is an optional, human readable description of the holder
+	// that is recorded as part of the Mutex's OwnerInfo. It can be
+	// overridden for a single lock attempt using WithLabel.
+	Label string
+
+	// RemoveOnClose, when true, removes the lock file when the Mutex is
+	// closed. The file is only removed if no other process holds it.
+	// Processes waiting for the removed file notice that it was removed
+	// and lock the file that replaces it.
+	//
+	// This option only applies to unix systems.
+	RemoveOnClose bool
+
+	// Backend selects the OS mechanism used to implement the Mutex.
+	// Processes must use the same Backend to reference the Mutex.
+	// The zero value is DefaultBackend.
+	Backend Backend
+
+	// LeaseTTL, when greater than zero, turns the Mutex into a lease.
+	// Rather than being held until it is unlocked, the lock expires
+	// after LeaseTTL unless it is renewed. The holder renews the lease
+	// in the background for as long as it holds the lock. If the holder
+	// stops renewing the lease (e.g., because it hangs), a waiting
+	// routine in any process takes over the lease once it expires.
+	// A holder can find out that its lease lapsed using Lost.
+	//
+	// In lease mode, the OS mutex is only held while the lease is read
+	// or updated. The lease is stored in a file whose path is the
+	// resource's path suffixed with '.lease'. Processes must use the
+	// same LeaseTTL to reference the Mutex. Be advised that a process
+	// which hangs while it is updating the lease (which only takes
+	// a moment) prevents other processes from taking over the lease
+	// until it resumes or exits.
+	//
+	// This option only applies to unix systems, and requires a backend
+	// that locks a file.
+	LeaseTTL time.Duration
+
+	// FencingTokens, when true, makes every acquisition of the Mutex
+	// yield a fencing token. A fencing token is a number that is
+	// strictly greater than the token of any previous acquisition of
+	// the Mutex, in any process. A holder can pass its token to
+	// downstream storage, which can then reject requests that carry
+	// a token older than the newest one it has seen. This protects the
+	// storage from a holder that was paused (and lost the Mutex) while
+	// it was using the storage.
+	//
+	// The last token is stored in a file whose path is the resource's
+	// path suffixed with '.token'. It is updated while the Mutex is
+	// held, and is flushed to the storage device before the Mutex is
+	// locked. All processes that lock the Mutex should enable this
+	// option. If the file does not contain a valid token, lock attempts
+	// fail with a *LockError whose FencingTokenCorrupt method returns
+	// true (Lock panics, since the Mutex cannot be used until the file
+	// is fixed). Refer to Mutex.LockToken and CurrentToken for more
+	// information.
+	//
+	// This option only applies to unix systems, and requires a backend
+	// that locks a file.
+	FencingTokens bool
+
+	// RetryPolicy controls how failed OS mutex lock attempts are
+	// retried. The zero value retries every 100 milliseconds for as
+	// long as the caller is willing to wait. Refer to RetryPolicy for
+	// more information.
+	RetryPolicy RetryPolicy
+
+	// Fair, when true, grants the Mutex to processes in the order in
+	// which they started waiting for it. Without this option, whichever
+	// process happens to lock the OS mutex first after it is released
+	// wins, which can starve other processes under heavy contention.
+	//
+	// Waiters take a ticket from a queue stored in a file whose path is
+	// the resource's path suffixed with '.queue'. Tickets are stored in
+	// a directory whose path is the resource's path suffixed with
+	// '.tickets'. All processes that lock the Mutex must enable this
+	// option for the ordering to hold. Routines within the same process
+	// are ordered by the in-process mutex.
+	//
+	// This option only applies to unix systems, and requires a backend
+	// that locks a file. It cannot be combined with LeaseTTL.
+	Fair bool
+
+	// PriorityAging controls how waiters with different priorities
+	// (refer to WithPriority) are ordered when Fair is enabled. A waiter
+	// is ordered as if it started waiting PriorityAging earlier for
+	// each level of priority. In other words, a waiter is only passed
+	// by higher priority waiters that start waiting less than
+	// PriorityAging times the difference in priority after it did.
+	// This prevents lower priority waiters from starving.
+	//
+	// The zero value is one second.
+	PriorityAging time.Duration
+
+	// DeadlockRegistry, when not empty, enables deadlock detection. It
+	// is the fully qualified path of a directory in which processes
+	// record the resources they hold and wait for. While a routine waits
+	// for the OS mutex, the records are combined into a wait-for graph.
+	// If waiting would complete a cycle in the graph (e.g., the current
+	// process holds resource A and waits for B, while another process
+	// holds B and waits for A), the lock attempt fails with a *LockError
+	// whose DeadlockDetected method returns true. Methods which cannot
+	// return an error (such as Mutex.Lock) do not give up. They keep
+	// waiting, but their holds and waits are still recorded so that
+	// other lock attempts can detect the cycle.
+	//
+	// Holds and waits are recorded per process rather than per routine,
+	// so a cycle may be reported even though the routine holding the
+	// resource would eventually unlock it. All processes sharing
+	// resources must use the same directory, and must share a PID
+	// namespace.
+	//
+	// This option only applies to unix systems.
+	DeadlockRegistry string
+
+	// Observer, when not nil, is notified about the Mutex's lock
+	// attempts, including how long routines wait for and hold the
+	// Mutex. Refer to Observer for more information.
+	Observer Observer
+
+	// Scope determines which processes share the Mutex. The zero value
+	// is DefaultScope, which uses Resource as is.
+	//
+	// Any other Scope treats Resource as a logical name that works the
+	// same way on every operating system. The name can contain any
+	// character. It is mapped to a name that only contains ASCII
+	// letters, digits, '-', '_' and '.'. Names that had to be changed
+	// or that are long are shortened and suffixed with a hash of the
+	// original name. The mapped name is then placed in a location that
+	// depends on the Scope. Refer to SystemScope and UserScope for more
+	// information.
+	Scope Scope
+}
+
+// Backend is an OS mechanism that can be used to implement a Mutex.
+type Backend int
+
+const (
+	// DefaultBackend uses the operating system's default mechanism.
+	// On unix systems, this is a file locked with flock(2). On Windows,
+	// this is a named mutex object.
+	DefaultBackend Backend = iota
+
+	// AbstractSocketBackend binds a Unix domain socket in the Linux
+	// abstract namespace. The socket's name is derived from
+	// MutexConfig.Resource, which does not need to be a file path.
+	// No files are created, and the kernel releases the socket when
+	// the holder exits.
+	//
+	// Be advised that the abstract namespace is specific to a network
+	// namespace. Processes in different network namespaces (e.g.,
+	// different containers) do not exclude each other.
+	//
+	// This backend is only supported on Linux.
+	AbstractSocketBackend
+
+	// OFDBackend locks a file using open file description (OFD) locks
+	// (fcntl(2) with F_OFD_SETLK and F_OFD_SETLKW) rather than flock(2).
+	// OFD locks are implemented as POSIX record locks, which are more
+	// reliable than flock(2) on some network filesystems. Like the
+	// default backend, MutexConfig.Resource must be a fully qualified
+	// file path.
+	//
+	// OFD locks and flock(2) locks do not exclude each other.
+	//
+	// This backend is only supported on Linux.
+	OFDBackend
+)
+
+// String returns the name of the Backend.
+func (o Backend) String() string {
+	switch o {
+	case DefaultBackend:
+		return "default"
+	case AbstractSocketBackend:
+		return "abstract-socket"
+	case OFDBackend:
+		return "ofd"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(o))
+	}
 }
 
 func (o *MutexConfig) validate() error {
@@ -44,6 +229,30 @@ func (o *MutexConfig) validate() error {
 		}
 	}
 
+	err := o.RetryPolicy.validate()
+	if err != nil {
+		return err
+	}
+
+	err = o.Scope.validate()
+	if err != nil {
+		return err
+	}
+
+	if o.PriorityAging < 0 {
+		return &ConfigureError{
+			reason: fmt.Sprintf("%s the priority aging cannot be negative - %s",
+				configureErrPrefix, o.PriorityAging.String()),
+		}
+	}
+
+	if o.LeaseTTL < 0 {
+		return &ConfigureError{
+			reason: fmt.Sprintf("%s the lease ttl cannot be negative - %s",
+				configureErrPrefix, o.LeaseTTL.String()),
+		}
+	}
+
 	return nil
 }
 
@@ -62,52 +271,253 @@ type Mutex interface {
 	// Be advised that this call will block until the mutex can be locked.
 	// If an error occurs while trying to lock the Mutex, the method will
 	// keep trying. Any underlying errors that occur when locking the OS
-	// mutex are hidden from the caller when using this method.
+	// mutex are hidden from the caller when using this method. This call
+	// will panic if the Mutex has been closed.
 	Lock()
 
+	// LockContext locks the Mutex, or gives up when the provided
+	// context.Context is done. If the context is done before the Mutex
+	// is locked, ctx.Err() is returned and the Mutex is not locked.
+	//
+	// Unlike Lock, errors encountered while locking the OS mutex are
+	// returned to the caller if the context can be cancelled.
+	LockContext(ctx context.Context) error
+
 	// TimedTryLock attempts to lock the Mutex within the specified
 	// timeout. A non-nil error is returned when the Mutex cannot be
 	// locked in time.
 	TimedTryLock(time.Duration) error
 
 	// Unlock unlocks the Mutex. Like sync.Mutex, this call will panic
-	// if the Mutex is already unlocked.
+	// if the Mutex is already unlocked. Errors encountered while
+	// unlocking the OS mutex are ignored. Use UnlockErr to find out
+	// about them.
 	Unlock()
+
+	// UnlockErr unlocks the Mutex like Unlock, but returns
+	// an *UnlockError if the OS mutex could not be unlocked. Other
+	// processes may be unable to lock the Mutex when this happens.
+	// The Mutex is unlocked within the current process regardless.
+	UnlockErr() error
+
+	// LockToken locks the Mutex like LockContext and returns the
+	// fencing token of the acquisition. A *ConfigureError is returned
+	// if fencing tokens are not enabled (refer to
+	// MutexConfig.FencingTokens).
+	LockToken(ctx context.Context) (uint64, error)
+
+	// Token returns the fencing token of the current acquisition of the
+	// Mutex, regardless of how the Mutex was locked. It returns false if
+	// the Mutex is not locked, or if fencing tokens are not enabled.
+	// It should only be called by the holder of the Mutex.
+	Token() (uint64, bool)
+
+	// Lost returns a channel that is closed if the Mutex's lease
+	// lapses while the Mutex is locked, meaning that another routine
+	// may have taken over the lock. The holder should stop using
+	// the resource protected by the Mutex once this happens. Refer to
+	// MutexConfig.LeaseTTL for more information.
+	//
+	// The channel is not closed when the Mutex is unlocked. A nil
+	// channel (which is never closed) is returned if the Mutex is not
+	// locked, or if it does not use a lease.
+	Lost() <-chan struct{}
+
+	// Close releases the OS resources used by the Mutex. The Mutex must
+	// be unlocked. Subsequent lock attempts fail with a *LockError
+	// whose MutexClosed method returns true.
+	Close() error
 }
 
-// timedSyncMutexLock attempts to lock the supplied *sync.Mutex within the
-// specified timeout. If successful, the function returns the remaining
-// timeout. A non-nil error is returned if the lock attempt exceeds
-// the timeout.
-func timedSyncMutexLock(mutex *sync.Mutex, timeout time.Duration) (time.Duration, error) {
-	start := time.Now()
-	mutexOwnership := make(chan struct{})
+// syncMutex is an in-process mutex. Unlike a sync.Mutex, a routine
+// waiting to lock a syncMutex can give up without leaving anything
+// behind (such as a routine that is still blocked on the mutex).
+type syncMutex chan struct{}
 
-	go func() {
-		mutex.Lock()
+func newSyncMutex() syncMutex {
+	return make(syncMutex, 1)
+}
 
-		_, shouldStayLocked := <-mutexOwnership
-		if !shouldStayLocked {
-			// The main routine closed the channel because it
-			// gave up.
-			mutex.Unlock()
-		}
-	}()
+// lockContext locks the syncMutex. It returns ctx.Err() if the context
+// is done before the syncMutex can be locked. An already done context
+// still gets one chance to lock the syncMutex if it is available.
+func (o syncMutex) lockContext(ctx context.Context) error {
+	select {
+	case o <- struct{}{}:
+		return nil
+	default:
+	}
 
-	timeoutExceeded := time.NewTimer(timeout)
+	select {
+	case o <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tryLock locks the syncMutex if it is available. It returns false if
+// the syncMutex is already locked.
+func (o syncMutex) tryLock() bool {
+	select {
+	case o <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
 
+// unlock unlocks the syncMutex. Like sync.Mutex, it panics if the
+// syncMutex is not locked.
+func (o syncMutex) unlock() {
 	select {
-	case <-timeoutExceeded.C:
-		close(mutexOwnership)
-		return 0, &LockError{
-			reason:      fmt.Sprintf("%s *sync.Mutex lock attempt exceeded timeout of %s",
-				unableToAcquirePrefix, timeout.String()),
-			syncTimeout: true,
+	case <-o:
+	default:
+		panic("ipcm: unlock of unlocked mutex")
+	}
+}
+
+// timedTryLock attempts to lock a mutex within the specified timeout.
+// The in-process mutex is locked using lockSync, followed by the OS
+// mutex using lockOs. If the OS mutex cannot be locked, unlockSync is
+// called to release the in-process mutex.
+//
+// Timeouts are reported as a *LockError. The timeout applies on top of
+// any deadline of the parent context.
+func timedTryLock(parent context.Context, timeout time.Duration, lockSync func(context.Context) error, lockOs func(context.Context) error, unlockSync func()) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	err := lockSync(ctx)
+	if err != nil {
+		if err == ctx.Err() {
+			return newSyncTimeoutError(timeout)
 		}
-	case mutexOwnership <- struct{}{}:
-		// The background routine has successfully locked the mutex.
-		timeoutExceeded.Stop()
-		// TODO: What happens if timeout == 0?
-		return timeout - time.Since(start), nil
+		return err
+	}
+
+	err = lockOs(ctx)
+	if err != nil {
+		unlockSync()
+		if err == ctx.Err() {
+			return newSystemTimeoutError(timeout)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// sleepContext sleeps for the specified duration. It returns ctx.Err()
+// if the context is done before the duration elapses.
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isClosed returns true if the channel is closed. A nil channel is
+// never closed.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// isInfinite returns true if the context can never be done.
+func isInfinite(ctx context.Context) bool {
+	return ctx.Done() == nil
+}
+
+func newSyncTimeoutError(timeout time.Duration) *LockError {
+	return &LockError{
+		reason:      fmt.Sprintf("%s in-process mutex lock attempt exceeded timeout of %s",
+			unableToAcquirePrefix, timeout.String()),
+		syncTimeout: true,
+	}
+}
+
+func newSystemTimeoutError(timeout time.Duration) *LockError {
+	return &LockError{
+		reason:        fmt.Sprintf(exceededOsLockTimeout, timeout.String()),
+		systemTimeout: true,
+	}
+}
+
+func newUnsupportedBackendError(backend Backend) *ConfigureError {
+	return &ConfigureError{
+		reason:       fmt.Sprintf("%s the %s backend is not supported here",
+			configureErrPrefix, backend.String()),
+		notSupported: true,
+	}
+}
+
+func newUnsupportedOptionError(option string, kind string) *ConfigureError {
+	return &ConfigureError{
+		reason: fmt.Sprintf("%s %s are not supported by %s here",
+			configureErrPrefix, option, kind),
+		notSupported: true,
+	}
+}
+
+func newTokensDisabledError() *ConfigureError {
+	return &ConfigureError{
+		reason: fmt.Sprintf("%s fencing tokens are not enabled for the mutex",
+			configureErrPrefix),
+	}
+}
+
+func newClosedError() *LockError {
+	return &LockError{
+		reason: fmt.Sprintf("%s the mutex has been closed", unableToAcquirePrefix),
+		closed: true,
+	}
+}
+
+func newStillLockedError() *CloseError {
+	return &CloseError{
+		reason: fmt.Sprintf("%s the mutex is locked", closeErrPrefix),
+		locked: true,
+	}
+}
+
+func newAlreadyClosedError() *CloseError {
+	return &CloseError{
+		reason: fmt.Sprintf("%s the mutex has already been closed", closeErrPrefix),
+		closed: true,
+	}
+}
+
+// blockingContextKey marks the context of lock methods that cannot
+// return an error, such as Mutex.Lock.
+type blockingContextKey struct{}
+
+// blockingContext returns the context used by lock methods that cannot
+// return an error. Such attempts are never given up, regardless of
+// RetryPolicy.MaxAttempts and MutexConfig.DeadlockRegistry.
+func blockingContext() context.Context {
+	return context.WithValue(context.Background(), blockingContextKey{}, true)
+}
+
+// isBlocking returns true if the context was created by blockingContext.
+func isBlocking(ctx context.Context) bool {
+	blocking, _ := ctx.Value(blockingContextKey{}).(bool)
+	return blocking
+}
+
+// panicOnLockError is used by lock methods that cannot return an error.
+// Such methods only fail when the mutex cannot be used anymore.
+func panicOnLockError(err error) {
+	if err != nil {
+		panic("ipcm: " + err.Error())
 	}
 }
@@ -2,8 +2,11 @@ package ipcm
 
 import (
 	"bytes"
+	"context"
 	"io/ioutil"
+	"os"
 	"path"
+	"runtime"
 	"strconv"
 	"sync"
 	"testing"
@@ -67,6 +70,274 @@ func TestNewMutex_TimedTryLock(t *testing.T) {
 	m.Unlock()
 }
 
+func TestNewMutex_LockContext(t *testing.T) {
+	env := setupTestEnv(t)
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	lockTimeout := 2 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err = m.LockContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("lock attempt should have failed with %v - got %v",
+			context.DeadlineExceeded, err)
+	}
+
+	duration := time.Since(start)
+	if duration < lockTimeout {
+		t.Fatalf("lock attempt only lasted %s when it should have taken at least %s",
+			duration.String(), lockTimeout.String())
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(lockTimeout)
+		cancel()
+	}()
+
+	err = m.LockContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("lock attempt should have failed with %v - got %v",
+			context.Canceled, err)
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	err = m.LockContext(context.Background())
+	if err != nil {
+		t.Fatalf("lock should have succeeded, but it failed - %s", err.Error())
+	}
+	m.Unlock()
+}
+
+func TestNewMutex_LockContextInProcess(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m.Lock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = m.LockContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("lock attempt should have failed with %v - got %v",
+			context.Canceled, err)
+	}
+
+	m.Unlock()
+
+	err = m.LockContext(ctx)
+	if err != nil {
+		t.Fatalf("lock of an unlocked mutex with a done context should succeed - got %s",
+			err.Error())
+	}
+	m.Unlock()
+}
+
+func TestNewMutex_LockFileDeleted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mutex resources are not files on windows")
+	}
+
+	env := setupTestEnv(t)
+	firstHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		firstHarness.Process.Kill()
+		firstHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- m.LockContext(ctx)
+	}()
+
+	// Let the routine start waiting on the original lock file before
+	// replacing it.
+	time.Sleep(500 * time.Millisecond)
+
+	err = os.Remove(env.mutexConfig.Resource)
+	if err != nil {
+		t.Fatalf("failed to remove lock file - %s", err.Error())
+	}
+
+	secondHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		secondHarness.Process.Kill()
+		secondHarness.Wait()
+	}()
+
+	// The waiting routine now gets the lock on the deleted file, which
+	// must not be mistaken for owning the mutex.
+	firstHarness.Process.Kill()
+	firstHarness.Wait()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("mutex was locked while another process holds the replacement lock file - %v", err)
+	case <-time.After(time.Second):
+	}
+
+	secondHarness.Process.Kill()
+	secondHarness.Wait()
+
+	err = <-acquired
+	if err != nil {
+		t.Fatalf("lock should have succeeded, but it failed - %s", err.Error())
+	}
+	m.Unlock()
+}
+
+func TestNewMutex_Close(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m.Lock()
+
+	err = m.Close()
+	closeErr, ok := err.(*CloseError)
+	if !ok || !closeErr.StillLocked() {
+		t.Fatalf("closing a locked mutex should fail with a still locked error - got %v", err)
+	}
+
+	m.Unlock()
+
+	err = m.Close()
+	if err != nil {
+		t.Fatalf("failed to close mutex - %s", err.Error())
+	}
+
+	err = m.TimedTryLock(time.Second)
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.MutexClosed() {
+		t.Fatalf("locking a closed mutex should fail with a closed error - got %v", err)
+	}
+
+	err = m.LockContext(context.Background())
+	lockErr, ok = err.(*LockError)
+	if !ok || !lockErr.MutexClosed() {
+		t.Fatalf("locking a closed mutex should fail with a closed error - got %v", err)
+	}
+
+	err = m.Close()
+	closeErr, ok = err.(*CloseError)
+	if !ok || !closeErr.AlreadyClosed() {
+		t.Fatalf("closing a closed mutex should fail with an already closed error - got %v", err)
+	}
+
+	// Other mutexes for the same resource must be unaffected.
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	err = other.TimedTryLock(time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded - %s", err.Error())
+	}
+	other.Unlock()
+}
+
+func TestNewMutex_GarbageCollected(t *testing.T) {
+	env := setupTestEnv(t)
+
+	isLive := func() bool {
+		for _, state := range LiveMutexes() {
+			if state.Resource == env.mutexConfig.Resource {
+				return true
+			}
+		}
+		return false
+	}
+
+	func() {
+		_, err := NewMutex(env.mutexConfig)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}()
+
+	if !isLive() {
+		t.Fatal("mutex should be live before it is garbage collected")
+	}
+
+	for i := 0; i < 10 && isLive(); i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if isLive() {
+		t.Fatal("mutex should not be live once it has been garbage collected")
+	}
+}
+
+func TestNewMutex_UnlockErr(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	for i := 0; i < 3; i++ {
+		err = m.TimedTryLock(time.Second)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		err = m.UnlockErr()
+		if err != nil {
+			t.Fatalf("failed to unlock mutex - %s", err.Error())
+		}
+
+		err = other.TimedTryLock(time.Second)
+		if err != nil {
+			t.Fatalf("lock should have succeeded once the mutex was unlocked - %s", err.Error())
+		}
+
+		err = other.UnlockErr()
+		if err != nil {
+			t.Fatalf("failed to unlock mutex - %s", err.Error())
+		}
+	}
+}
+
 func TestNewMutex_MultipleRoutines(t *testing.T) {
 	env := setupTestEnv(t)
 	m, err := NewMutex(env.mutexConfig)
@@ -96,8 +367,20 @@ func TestNewMutex_MultipleRoutines(t *testing.T) {
 }
 
 func TestNewMutex_MultipleRoutinesIpc(t *testing.T) {
-	env := setupTestEnv(t)
+	for _, backend := range supportedBackends(t) {
+		t.Run(backend.String(), func(t *testing.T) {
+			env := setupTestEnv(t)
+			env.mutexConfig.Backend = backend
+
+			testMultipleRoutinesIpc(env, t)
+		})
+	}
+}
 
+// testMultipleRoutinesIpc has several routines in the current process and
+// in the test harness increment a value stored in a file while holding
+// the mutex.
+func testMultipleRoutinesIpc(env testEnv, t *testing.T) {
 	ipcFilePath := path.Join(env.dataDirPath, "ipc-test.txt")
 	err := ioutil.WriteFile(ipcFilePath, []byte{'0'}, 0600)
 	if err != nil {
@@ -143,18 +426,21 @@ func TestNewMutex_MultipleRoutinesIpc(t *testing.T) {
 
 			raw, err := ioutil.ReadFile(ipcFilePath)
 			if err != nil {
-				t.Fatalf("failed to read IPC test file - %s", err.Error())
+				t.Errorf("failed to read IPC test file - %s", err.Error())
+				return
 			}
 
 			v, err := strconv.Atoi(string(raw))
 			if err != nil {
-				t.Fatalf("failed to read an integer from IPC test file - %s", err.Error())
+				t.Errorf("failed to read an integer from IPC test file - %s", err.Error())
+				return
 			}
 
 			v++
 			err = ioutil.WriteFile(ipcFilePath, []byte(strconv.Itoa(v)), 0600)
 			if err != nil {
-				t.Fatalf("failed to write to IPC test file - %s", err.Error())
+				t.Errorf("failed to write to IPC test file - %s", err.Error())
+				return
 			}
 		}()
 	}
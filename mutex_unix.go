@@ -3,134 +3,432 @@
 package ipcm
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"path"
-	"sync"
+	"runtime"
 	"time"
 
 	"golang.org/x/sys/unix"
 )
 
-const (
-	dirMode  = 0755
-	lockMode = 0644
-)
-
 type unixMutex struct {
-	mutex  *sync.Mutex
-	file   *os.File
-	config MutexConfig
+	mutex     syncMutex
+	locker    osLocker
+	locked    bool
+	closed    bool
+	token     uint64
+	status    *mutexStatus
+	deadlocks *deadlockRegistry
+	config    MutexConfig
 }
 
 func (o *unixMutex) Lock() {
-	o.mutex.Lock()
+	panicOnLockError(o.LockContext(blockingContext()))
+}
+
+func (o *unixMutex) LockContext(ctx context.Context) error {
+	ctx, attempt := startLockAttempt(ctx, o.config)
+
+	err := o.lockSyncMutex(ctx)
+	if err != nil {
+		attempt.failed(err, false)
+		return err
+	}
+
+	err = o.lockOsMutexUnsafe(ctx)
+	if err != nil {
+		o.mutex.unlock()
+		attempt.failed(err, true)
+		return err
+	}
+
+	o.status.acquired(attempt.acquired())
+
+	return nil
+}
+
+func (o *unixMutex) LockToken(ctx context.Context) (uint64, error) {
+	if !o.config.FencingTokens {
+		return 0, newTokensDisabledError()
+	}
 
-	o.lockOsMutexUnsafe(-1)
+	err := o.LockContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return o.token, nil
+}
+
+func (o *unixMutex) Token() (uint64, bool) {
+	if !o.locked || !o.config.FencingTokens {
+		return 0, false
+	}
+
+	return o.token, true
 }
 
 func (o *unixMutex) TimedTryLock(timeout time.Duration) error {
-	remaining, err := timedSyncMutexLock(o.mutex, timeout)
+	ctx, attempt := startLockAttempt(context.Background(), o.config)
+
+	err := timedTryLock(ctx, timeout, o.lockSyncMutex, o.lockOsMutexUnsafe, o.mutex.unlock)
 	if err != nil {
-		return err
+		attempt.failed(err, true)
+	} else {
+		o.status.acquired(attempt.acquired())
 	}
 
-	err = o.lockOsMutexUnsafe(remaining)
+	if lockErr, ok := err.(*LockError); ok && lockErr.systemTimeout && usesLockFile(o.config) {
+		owner, ownerErr := readOwnerInfo(o.config)
+		if ownerErr == nil {
+			lockErr.reason = fmt.Sprintf("%s - held by %s", lockErr.reason, owner.String())
+			lockErr.owner = &owner
+		}
+	}
+
+	return err
+}
+
+// lockSyncMutex locks the in-process mutex. It fails if the Mutex has
+// been closed.
+func (o *unixMutex) lockSyncMutex(ctx context.Context) error {
+	o.status.waiting(1)
+	err := o.mutex.lockContext(ctx)
+	o.status.waiting(-1)
 	if err != nil {
-		o.mutex.Unlock()
 		return err
 	}
 
+	if o.closed {
+		o.mutex.unlock()
+		return newClosedError()
+	}
+
 	return nil
 }
 
-func (o *unixMutex) lockOsMutexUnsafe(timeout time.Duration) error {
-	start := time.Now()
-	sleep := 100 * time.Millisecond
+// lockOsMutexUnsafe locks the OS mutex. If deadlock detection is
+// enabled, the wait and the resulting hold are recorded in the deadlock
+// registry.
+func (o *unixMutex) lockOsMutexUnsafe(ctx context.Context) error {
+	if o.deadlocks == nil {
+		return o.acquireOsMutexUnsafe(ctx)
+	}
+
+	return o.deadlocks.lock(ctx, o.config.Resource, o.acquireOsMutexUnsafe)
+}
+
+// acquireOsMutexUnsafe locks the OS mutex. When a lock file is used,
+// information about the current process is recorded as its owner.
+// Failing to record the owner information does not prevent the Mutex
+// from being locked. If fencing tokens are enabled, failing to record
+// the next token is retried according to the RetryPolicy.
+func (o *unixMutex) acquireOsMutexUnsafe(ctx context.Context) error {
+	backoff := o.config.RetryPolicy.newBackoff()
 
 	for {
-		if timeout > 0 && time.Since(start) >= timeout {
-			return &LockError{
-				reason:        fmt.Sprintf(exceededOsLockTimeout, timeout.String()),
-				systemTimeout: true,
-			}
+		err := o.locker.lock(ctx)
+		if err != nil {
+			return err
 		}
 
-		if _, statErr := o.file.Stat(); statErr != nil {
-			err := o.resetFileUnsafe()
-			if err != nil {
-				time.Sleep(sleep)
-				continue
-			}
+		if !o.config.FencingTokens {
+			break
+		}
+
+		o.token, err = o.nextTokenUnsafe()
+		if err == nil {
+			break
+		}
+
+		o.locker.unlock()
+
+		if lockErr, ok := err.(*LockError); ok && lockErr.tokenCorrupt {
+			return err
 		}
 
-		flockErr := unix.Flock(int(o.file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
-		if flockErr == nil {
-			return nil
+		err = backoff.wait(ctx)
+		if err != nil {
+			return err
 		}
+	}
+
+	o.locked = true
 
-		time.Sleep(sleep)
+	if usesLockFile(o.config) {
+		writeOwnerInfo(o.config.Resource, newOwnerInfo(labelFromContext(ctx, o.config.Label)),
+			o.config.Scope == SystemScope)
 	}
+
+	return nil
 }
 
-func (o *unixMutex) resetFileUnsafe() error {
-	if o.file != nil {
-		o.file.Close()
+// nextTokenUnsafe records and returns the next fencing token. The OS
+// mutex must be locked. In lease mode, the token is recorded while the
+// lease's guard is locked so that a holder that lost its lease cannot
+// record a token.
+func (o *unixMutex) nextTokenUnsafe() (uint64, error) {
+	lease, ok := o.locker.(*leaseLocker)
+	if !ok {
+		return incrementToken(o.config.Resource)
 	}
 
-	err := os.MkdirAll(path.Dir(o.config.Resource), dirMode)
+	var token uint64
+	err := lease.guarded(func() error {
+		var err error
+		token, err = incrementToken(o.config.Resource)
+		return err
+	})
+
+	return token, err
+}
+
+func (o *unixMutex) Unlock() {
+	o.UnlockErr()
+}
+
+func (o *unixMutex) UnlockErr() error {
+	defer o.mutex.unlock()
+
+	if !o.locked {
+		return nil
+	}
+
+	lost := isClosed(o.Lost())
+
+	// If the lease was lost, the owner information may belong to
+	// whoever took over the lease.
+	if usesLockFile(o.config) && !lost {
+		removeOwnerInfo(o.config.Resource, o.config.Scope == SystemScope)
+	}
+
+	err := o.locker.unlock()
+	o.locked = false
+	if o.deadlocks != nil {
+		o.deadlocks.release(o.config.Resource)
+	}
+	notifyUnlocked(o.config, o.status.released())
 	if err != nil {
-		return &LockError{
-			reason:  fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
-			dirFail: true,
+		return &UnlockError{
+			reason:        fmt.Sprintf("%s %s", unlockErrPrefix, err.Error()),
+			syscallFailed: true,
 		}
 	}
 
-	o.file, err = os.OpenFile(o.config.Resource, os.O_RDONLY|os.O_CREATE, lockMode)
-	if err != nil {
-		return &LockError{
-			reason:     fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
-			createFail: true,
+	if lost {
+		return &UnlockError{
+			reason:    fmt.Sprintf("%s the lease was lost before the mutex was unlocked", unlockErrPrefix),
+			leaseLost: true,
 		}
 	}
 
 	return nil
 }
 
-func (o *unixMutex) Unlock() {
-	defer o.mutex.Unlock()
+func (o *unixMutex) lockOrderKey() string {
+	return lockOrderKey(o.config)
+}
+
+func (o *unixMutex) Lost() <-chan struct{} {
+	if lease, ok := o.locker.(*leaseLocker); ok {
+		return lease.lostChannel()
+	}
+
+	return nil
+}
 
-	if o.file == nil {
-		return
+func (o *unixMutex) Close() error {
+	if !o.mutex.tryLock() {
+		return newStillLockedError()
 	}
+	defer o.mutex.unlock()
 
-	unix.Flock(int(o.file.Fd()), unix.LOCK_UN)
+	if o.closed {
+		return newAlreadyClosedError()
+	}
+
+	o.closed = true
+	o.status.unregister()
+
+	err := o.locker.close(o.config.RemoveOnClose)
+	if err != nil {
+		return &CloseError{
+			reason:        fmt.Sprintf("%s %s", closeErrPrefix, err.Error()),
+			syscallFailed: true,
+		}
+	}
+
+	return nil
 }
 
 // NewMutex creates a new Mutex.
 func NewMutex(config MutexConfig) (Mutex, error) {
-	err := config.validate()
+	config, err := validateUnixConfig(config, true)
 	if err != nil {
 		return nil, err
 	}
 
+	var deadlocks *deadlockRegistry
+	if len(config.DeadlockRegistry) > 0 {
+		deadlocks, err = deadlockRegistryFor(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	locker, err := newOsLocker(config)
+	if err != nil {
+		return nil, err
+	}
+
+	mu := &unixMutex{
+		mutex:     newSyncMutex(),
+		locker:    locker,
+		status:    registerMutex(config),
+		deadlocks: deadlocks,
+		config:    config,
+	}
+
+	// A Mutex that is never closed must not remain live after it has
+	// been garbage collected.
+	runtime.SetFinalizer(mu, func(o *unixMutex) {
+		o.status.unregister()
+	})
+
+	return mu, nil
+}
+
+// validateUnixConfig validates a MutexConfig for use on unix systems.
+// It returns a copy of the MutexConfig whose resource is resolved
+// according to its Scope. The Scope's directory is only created if
+// create is true (refer to resolveScope).
+func validateUnixConfig(config MutexConfig, create bool) (MutexConfig, error) {
+	err := config.validate()
+	if err != nil {
+		return MutexConfig{}, err
+	}
+
+	if !usesLockFile(config) {
+		if config.Scope != DefaultScope {
+			return MutexConfig{}, newUnsupportedOptionError("scopes",
+				"the "+config.Backend.String()+" backend")
+		}
+
+		return config, nil
+	}
+
+	config, err = resolveScope(config, create)
+	if err != nil {
+		return MutexConfig{}, err
+	}
+
 	if !path.IsAbs(config.Resource) || len(config.Resource) == 1 {
-		return nil, &ConfigureError{
+		return MutexConfig{}, &ConfigureError{
 			reason: fmt.Sprintf("%s the specified resource is not a fully qualified file path - '%s'",
 				configureErrPrefix, config.Resource),
 			notAbs: true,
 		}
 	}
 
-	mu := &unixMutex{
-		mutex:  &sync.Mutex{},
-		config: config,
+	return config, nil
+}
+
+// usesLockFile returns true if the MutexConfig's backend locks a file
+// whose path is the configured resource.
+func usesLockFile(config MutexConfig) bool {
+	switch config.Backend {
+	case DefaultBackend, OFDBackend:
+		return true
+	default:
+		return false
 	}
+}
 
-	err = mu.resetFileUnsafe()
-	if err != nil {
-		return nil, err
+// lockMethodFor returns the lockMethod used by the MutexConfig's backend.
+func lockMethodFor(config MutexConfig) lockMethod {
+	if config.Backend == OFDBackend {
+		return ofdMethod
 	}
 
-	return mu, nil
+	return flockMethod
+}
+
+// osLocker is the OS mechanism that a unixMutex uses to exclude other
+// processes. It is not safe for concurrent use.
+type osLocker interface {
+	// lock locks the OS mechanism. Failures are retried until the
+	// context is done, at which point ctx.Err() is returned.
+	lock(ctx context.Context) error
+
+	// unlock unlocks the OS mechanism.
+	unlock() error
+
+	// close releases the OS resources used by the osLocker. If remove
+	// is true, any file created by the osLocker is removed if it is
+	// safe to do so.
+	close(remove bool) error
+}
+
+// newOsLocker creates the osLocker for the MutexConfig's backend.
+func newOsLocker(config MutexConfig) (osLocker, error) {
+	switch config.Backend {
+	case DefaultBackend, OFDBackend:
+		if config.Backend == OFDBackend && !ofdSupported {
+			return nil, newUnsupportedBackendError(config.Backend)
+		}
+
+		file := &lockFile{
+			path:   config.Resource,
+			method: lockMethodFor(config),
+			retry:  config.RetryPolicy,
+			shared: config.Scope == SystemScope,
+		}
+
+		err := file.resetUnsafe()
+		if err != nil {
+			return nil, err
+		}
+
+		if config.LeaseTTL > 0 {
+			if config.Fair {
+				return nil, newUnsupportedOptionError("fair locks", "leases")
+			}
+
+			return newLeaseLocker(file, config.LeaseTTL, config.RetryPolicy), nil
+		}
+
+		if config.Fair {
+			return newFairLocker(exclusiveLockFile{file}, config), nil
+		}
+
+		return exclusiveLockFile{file}, nil
+	case AbstractSocketBackend:
+		kind := "the " + config.Backend.String() + " backend"
+
+		if config.LeaseTTL > 0 {
+			return nil, newUnsupportedOptionError("leases", kind)
+		}
+
+		if config.FencingTokens {
+			return nil, newUnsupportedOptionError("fencing tokens", kind)
+		}
+
+		if config.Fair {
+			return nil, newUnsupportedOptionError("fair locks", kind)
+		}
+
+		return newAbstractSocketLocker(config.Resource, config.RetryPolicy)
+	default:
+		return nil, newUnsupportedBackendError(config.Backend)
+	}
+}
+
+// exclusiveLockFile is an osLocker that locks a lockFile exclusively.
+type exclusiveLockFile struct {
+	*lockFile
+}
+
+func (o exclusiveLockFile) lock(ctx context.Context) error {
+	return o.lockFile.lock(ctx, unix.LOCK_EX)
 }
@@ -3,7 +3,11 @@
 package ipcm
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
+	"time"
 )
 
 func TestNewMutex_RelativePath(t *testing.T) {
@@ -14,3 +18,173 @@ func TestNewMutex_RelativePath(t *testing.T) {
 		t.Fatal("acquisition of relative path did not fail")
 	}
 }
+
+func TestNewMutex_AbandonedWait(t *testing.T) {
+	env := setupTestEnv(t)
+
+	owner, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	waiter, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	owner.Lock()
+
+	for i := 0; i < 3; i++ {
+		err = waiter.TimedTryLock(100 * time.Millisecond)
+		if err == nil {
+			t.Fatal("lock attempt should have failed")
+		}
+	}
+
+	owner.Unlock()
+
+	// The abandoned waiter must not hold on to the lock once the owner
+	// releases it.
+	err = owner.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("owner failed to relock mutex - %s", err.Error())
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- waiter.TimedTryLock(5 * time.Second)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	owner.Unlock()
+
+	err = <-acquired
+	if err != nil {
+		t.Fatalf("waiter failed to lock mutex - %s", err.Error())
+	}
+	waiter.Unlock()
+}
+
+func TestNewMutex_CloseReleasesFiles(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("/proc/self/fd is not available")
+	}
+
+	env := setupTestEnv(t)
+
+	before := countOpenFiles(t)
+
+	for i := 0; i < 50; i++ {
+		config := env.mutexConfig
+		config.Resource = path.Join(env.dataDirPath, randStringBytesRmndr(10))
+
+		m, err := NewMutex(config)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		m.Lock()
+		m.Unlock()
+
+		err = m.Close()
+		if err != nil {
+			t.Fatalf("failed to close mutex - %s", err.Error())
+		}
+	}
+
+	after := countOpenFiles(t)
+	if after > before {
+		t.Fatalf("%d files were open before creating mutexes, %d are open after closing them",
+			before, after)
+	}
+}
+
+func TestNewMutex_RemoveOnClose(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.RemoveOnClose = true
+
+	owner, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	owner.Lock()
+
+	err = m.Close()
+	if err != nil {
+		t.Fatalf("failed to close mutex - %s", err.Error())
+	}
+
+	_, err = os.Stat(env.mutexConfig.Resource)
+	if err != nil {
+		t.Fatalf("lock file should not be removed while another mutex holds it - %s",
+			err.Error())
+	}
+
+	owner.Unlock()
+
+	err = owner.Close()
+	if err != nil {
+		t.Fatalf("failed to close mutex - %s", err.Error())
+	}
+
+	_, err = os.Stat(env.mutexConfig.Resource)
+	if !os.IsNotExist(err) {
+		t.Fatalf("lock file should have been removed - got %v", err)
+	}
+}
+
+// countOpenFiles returns the number of file descriptors that are open in
+// the current process.
+func countOpenFiles(t *testing.T) int {
+	infos, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("failed to read open file descriptors - %s", err.Error())
+	}
+
+	return len(infos)
+}
+
+// BenchmarkMutex_Handoff measures how long it takes for a waiter to lock
+// the mutex once the current owner unlocks it. Two Mutex objects are used
+// so that the hand off happens through the OS mutex.
+func BenchmarkMutex_Handoff(b *testing.B) {
+	env := setupTestEnv(b)
+
+	owner, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+
+	waiter, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+
+	b.StopTimer()
+
+	for i := 0; i < b.N; i++ {
+		owner.Lock()
+
+		acquired := make(chan struct{})
+		go func() {
+			waiter.Lock()
+			close(acquired)
+		}()
+
+		// Give the waiter a chance to start waiting on the OS mutex.
+		time.Sleep(5 * time.Millisecond)
+
+		b.StartTimer()
+		owner.Unlock()
+		<-acquired
+		b.StopTimer()
+
+		waiter.Unlock()
+	}
+}
@@ -1,8 +1,9 @@
 package ipcm
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"runtime"
 	"time"
 	"unsafe"
 
@@ -15,105 +16,201 @@ const (
 	releaseMutex        = "ReleaseMutex"
 	waitForSingleObject = "WaitForSingleObject"
 	globalPrefix        = "Global\\"
+	localPrefix         = "Local\\"
 )
 
-// TODO: Close handle?
 type windowsMutex struct {
 	config      MutexConfig
-	mutex       *sync.Mutex
+	mutex       syncMutex
 	winMutexApi *windowsMutexApi
 	mutexHandle uintptr
+	namespace   string
+	status      *mutexStatus
+	closed      bool
 }
 
 func (o *windowsMutex) Lock() {
-	o.mutex.Lock()
+	panicOnLockError(o.LockContext(blockingContext()))
+}
+
+func (o *windowsMutex) LockContext(ctx context.Context) error {
+	ctx, attempt := startLockAttempt(ctx, o.config)
+
+	err := o.lockSyncMutex(ctx)
+	if err != nil {
+		attempt.failed(err, false)
+		return err
+	}
 
-	o.lockOsMutexUnsafe(infiniteOsMutexLockTimeout)
+	err = o.lockOsMutexUnsafe(ctx)
+	if err != nil {
+		o.mutex.unlock()
+		attempt.failed(err, true)
+		return err
+	}
+
+	o.status.acquired(attempt.acquired())
+
+	return nil
 }
 
 func (o *windowsMutex) TimedTryLock(timeout time.Duration) error {
-	remaining, err := timedSyncMutexLock(o.mutex, timeout)
+	ctx, attempt := startLockAttempt(context.Background(), o.config)
+
+	err := timedTryLock(ctx, timeout, o.lockSyncMutex, o.lockOsMutexUnsafe, o.mutex.unlock)
 	if err != nil {
+		attempt.failed(err, true)
 		return err
 	}
 
-	err = o.lockOsMutexUnsafe(remaining)
+	o.status.acquired(attempt.acquired())
+
+	return nil
+}
+
+// lockSyncMutex locks the in-process mutex. It fails if the Mutex has
+// been closed.
+func (o *windowsMutex) lockSyncMutex(ctx context.Context) error {
+	o.status.waiting(1)
+	err := o.mutex.lockContext(ctx)
+	o.status.waiting(-1)
 	if err != nil {
-		o.mutex.Unlock()
 		return err
 	}
 
+	if o.closed {
+		o.mutex.unlock()
+		return newClosedError()
+	}
+
 	return nil
 }
 
-func (o *windowsMutex) lockOsMutexUnsafe(timeout time.Duration) error {
-	start := time.Now()
-
-	// TODO: Global should be an OS specific option.
+// lockOsMutexUnsafe locks the Windows mutex object. Failures are retried
+// according to the RetryPolicy, like on other operating systems, and
+// ctx.Err() is returned when the context is done.
+func (o *windowsMutex) lockOsMutexUnsafe(ctx context.Context) error {
 	// TODO: Should this be stored in the object as a field?
-	mutexId := uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(globalPrefix + o.config.Resource)))
-
-	mutexHandle, _, err := o.winMutexApi.createMutex.Call(0, 0, mutexId)
-	createMutexErrNum := int(err.(windows.Errno))
-	switch err.(windows.Errno) {
-	case 0, windows.ERROR_ALREADY_EXISTS:
-		// If the mutex already exists, the Windows API still returns
-		// a handle to the mutex.
-		break
-	default:
-		if timeout == infiniteOsMutexLockTimeout {
-			return o.lockOsMutexUnsafe(timeout)
+	mutexId := uintptr(unsafe.Pointer(windows.StringToUTF16Ptr(o.namespace + o.config.Resource)))
+
+	backoff := o.config.RetryPolicy.newBackoff()
+
+	for {
+		err := o.tryLockOsMutexUnsafe(ctx, mutexId)
+		if err == nil {
+			return nil
 		}
-		return &LockError{
-			reason:     fmt.Sprintf("%s got return code %d - %s",
-				unableToCreatePrefix, createMutexErrNum, err.Error()),
-			createFail: true,
+
+		if err == ctx.Err() {
+			return err
 		}
-	}
 
-	// Per the 'WaitForSingleObject' Windows API doc, the waitResult will
-	// be a non-zero value if a failure occurs. Therefore, we can treat
-	// the waitResult as an error condition. This appears to be a break
-	// in the Windows API pattern:
-	//  https://docs.microsoft.com/en-us/windows/desktop/api/synchapi/nf-synchapi-waitforsingleobject#return-value
-	waitResult, _, err := o.winMutexApi.waitForSingleObject.Call(mutexHandle, uintptr(timeout.Seconds() * 1000))
-	if timeout == infiniteOsMutexLockTimeout && waitResult != 0 {
-		return o.lockOsMutexUnsafe(infiniteOsMutexLockTimeout)
+		err = backoff.wait(ctx)
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	switch waitResult {
-	case windows.WAIT_OBJECT_0:
-		o.mutexHandle = mutexHandle
-		return nil
-	case windows.WAIT_ABANDONED:
-		return o.lockOsMutexUnsafe(timeout - time.Since(start))
-	case windows.WAIT_TIMEOUT:
-		return &LockError{
-			reason:        fmt.Sprintf(exceededOsLockTimeout, timeout.String()),
-			systemTimeout: true,
-		}
-	case windows.WAIT_FAILED:
-		waitForErrNum := int(err.(windows.Errno))
-		if waitForErrNum != 0 {
+func (o *windowsMutex) tryLockOsMutexUnsafe(ctx context.Context, mutexId uintptr) error {
+	if o.mutexHandle == 0 {
+		mutexHandle, _, err := o.winMutexApi.createMutex.Call(0, 0, mutexId)
+		createMutexErrNum := int(err.(windows.Errno))
+		switch err.(windows.Errno) {
+		case 0, windows.ERROR_ALREADY_EXISTS:
+			// If the mutex already exists, the Windows API still
+			// returns a handle to the mutex.
+			break
+		default:
 			return &LockError{
-				reason:        fmt.Sprintf("%s got return code %d - %s",
-					unableToAcquirePrefix, waitForErrNum, err.Error()),
-				syscallFailed: true,
+				reason:     fmt.Sprintf("%s got return code %d - %s",
+					unableToCreatePrefix, createMutexErrNum, err.Error()),
+				createFail: true,
 			}
 		}
+
+		// The handle is reused for subsequent lock attempts and is
+		// closed by Close.
+		o.mutexHandle = mutexHandle
 	}
 
-	return &LockError{
-		reason:         fmt.Sprintf("%s system mutex wait failed, got return code %d",
-			unableToAcquirePrefix, waitResult),
-		syscallFailed:  true,
+	for {
+		// Per the 'WaitForSingleObject' Windows API doc, the waitResult
+		// will be a non-zero value if a failure occurs. Therefore, we
+		// can treat the waitResult as an error condition. This appears
+		// to be a break in the Windows API pattern:
+		//  https://docs.microsoft.com/en-us/windows/desktop/api/synchapi/nf-synchapi-waitforsingleobject#return-value
+		waitResult, _, err := o.winMutexApi.waitForSingleObject.Call(o.mutexHandle, uintptr(waitMilliseconds(ctx)))
+		switch waitResult {
+		case windows.WAIT_OBJECT_0, windows.WAIT_ABANDONED:
+			// An abandoned mutex is owned by the caller once the
+			// wait completes. The previous owner exited without
+			// releasing it.
+			return nil
+		case windows.WAIT_TIMEOUT:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		case windows.WAIT_FAILED:
+			waitForErrNum := int(err.(windows.Errno))
+			if waitForErrNum != 0 {
+				return &LockError{
+					reason:        fmt.Sprintf("%s got return code %d - %s",
+						unableToAcquirePrefix, waitForErrNum, err.Error()),
+					syscallFailed: true,
+				}
+			}
+		}
+
+		return &LockError{
+			reason:         fmt.Sprintf("%s system mutex wait failed, got return code %d",
+				unableToAcquirePrefix, waitResult),
+			syscallFailed:  true,
+		}
+	}
+}
+
+// waitMilliseconds returns the number of milliseconds to pass to
+// 'WaitForSingleObject' for the given context. Contexts that can be
+// cancelled without a deadline are checked every osMutexRetryInterval.
+func waitMilliseconds(ctx context.Context) uint32 {
+	if isInfinite(ctx) {
+		return windows.INFINITE
 	}
+
+	wait := osMutexRetryInterval
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		remaining := time.Until(deadline)
+		if remaining < 0 {
+			remaining = 0
+		}
+		if remaining < wait {
+			wait = remaining
+		}
+	}
+
+	return uint32(wait / time.Millisecond)
 }
 
 func (o *windowsMutex) Unlock() {
-	o.unlockUnsafe()
+	o.UnlockErr()
+}
+
+func (o *windowsMutex) UnlockErr() error {
+	defer o.mutex.unlock()
+
+	err := o.unlockUnsafe()
+	notifyUnlocked(o.config, o.status.released())
+	if err != nil {
+		return &UnlockError{
+			reason:        fmt.Sprintf("%s %s", unlockErrPrefix, err.Error()),
+			syscallFailed: true,
+		}
+	}
 
-	o.mutex.Unlock()
+	return nil
 }
 
 func (o *windowsMutex) unlockUnsafe() error {
@@ -126,6 +223,59 @@ func (o *windowsMutex) unlockUnsafe() error {
 	return nil
 }
 
+// LockToken always fails because fencing tokens are not supported on
+// Windows.
+func (o *windowsMutex) LockToken(ctx context.Context) (uint64, error) {
+	return 0, newTokensDisabledError()
+}
+
+// Token always returns false because fencing tokens are not supported
+// on Windows.
+func (o *windowsMutex) Token() (uint64, bool) {
+	return 0, false
+}
+
+// lockOrderKey includes the kernel object namespace, because Mutexes
+// with the same resource in different Scopes reference different mutex
+// objects.
+func (o *windowsMutex) lockOrderKey() string {
+	return o.namespace + lockOrderKey(o.config)
+}
+
+// Lost always returns nil because leases are not supported on Windows.
+func (o *windowsMutex) Lost() <-chan struct{} {
+	return nil
+}
+
+func (o *windowsMutex) Close() error {
+	if !o.mutex.tryLock() {
+		return newStillLockedError()
+	}
+	defer o.mutex.unlock()
+
+	if o.closed {
+		return newAlreadyClosedError()
+	}
+
+	o.closed = true
+	o.status.unregister()
+
+	if o.mutexHandle == 0 {
+		return nil
+	}
+
+	err := windows.CloseHandle(windows.Handle(o.mutexHandle))
+	o.mutexHandle = 0
+	if err != nil {
+		return &CloseError{
+			reason:        fmt.Sprintf("%s %s", closeErrPrefix, err.Error()),
+			syscallFailed: true,
+		}
+	}
+
+	return nil
+}
+
 type windowsMutexApi struct {
 	kernel32            *windows.LazyDLL
 	createMutex         *windows.LazyProc
@@ -144,17 +294,50 @@ func NewMutex(config MutexConfig) (Mutex, error) {
 		return nil, err
 	}
 
+	config, err = resolveScope(config, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Backend != DefaultBackend {
+		return nil, newUnsupportedBackendError(config.Backend)
+	}
+
+	if config.LeaseTTL > 0 {
+		return nil, newUnsupportedOptionError("leases", "Mutex")
+	}
+
+	if config.FencingTokens {
+		return nil, newUnsupportedOptionError("fencing tokens", "Mutex")
+	}
+
+	if config.Fair {
+		return nil, newUnsupportedOptionError("fair locks", "Mutex")
+	}
+
+	if len(config.DeadlockRegistry) > 0 {
+		return nil, newUnsupportedOptionError("deadlock detection", "Mutex")
+	}
+
 	winApi, err := loadWindowsMutexApi()
 	if err != nil {
 		return nil, err
 	}
 
 	mu := &windowsMutex{
-		mutex:       &sync.Mutex{},
+		mutex:       newSyncMutex(),
 		config:      config,
 		winMutexApi: winApi,
+		namespace:   namespaceFor(config.Scope),
+		status:      registerMutex(config),
 	}
 
+	// A Mutex that is never closed must not remain live after it has
+	// been garbage collected.
+	runtime.SetFinalizer(mu, func(o *windowsMutex) {
+		o.status.unregister()
+	})
+
 	return mu, nil
 }
 
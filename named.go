@@ -0,0 +1,168 @@
+package ipcm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxNameLength is the maximum length of a sanitized name.
+	maxNameLength = 64
+
+	// nameHashLength is the number of hex digits of a name's hash that
+	// are appended to a name that had to be sanitized.
+	nameHashLength = 16
+)
+
+// Scope determines which processes share a Mutex. Refer to
+// MutexConfig.Scope for more information.
+type Scope int
+
+const (
+	// DefaultScope uses MutexConfig.Resource as is. On unix systems,
+	// it is the path of the lock file. On Windows, the mutex object is
+	// created in the 'Global\' kernel object namespace.
+	DefaultScope Scope = iota
+
+	// SystemScope shares the Mutex with every process on the system,
+	// regardless of the user that runs it.
+	//
+	// On unix systems, the lock file is created in '/run/lock/ipcm',
+	// '/var/lock/ipcm', or an 'ipcm' directory in the temporary
+	// directory, whichever parent directory exists first. Every user
+	// resolves the same directory. A *ConfigureError is returned if the
+	// current user cannot create it (e.g., if '/run/lock' is only
+	// writable by root). Any user can create files in the directory,
+	// and lock files are readable and writable by all users. Like the
+	// temporary directory, the directory's sticky bit prevents users
+	// from removing or replacing each other's files. As a result, only
+	// processes run by the same user should use options that replace
+	// files next to the lock file (such as LeaseTTL, FencingTokens and
+	// Fair). An existing directory is only used if it is owned by root
+	// or the current user and has the same mode, and symbolic links in
+	// it are never followed. On Windows, the mutex object is created in
+	// the 'Global\' kernel object namespace.
+	SystemScope
+
+	// UserScope shares the Mutex with processes run by the current
+	// user only. Processes run by other users that use the same name
+	// reference a different Mutex.
+	//
+	// On unix systems, the lock file is created in '/run/user/<uid>/ipcm'
+	// if the user's runtime directory exists, or in an 'ipcm-<uid>'
+	// directory in the temporary directory otherwise. The directory is
+	// only accessible by the current user. On Windows, the mutex object is created in the
+	// 'Local\' kernel object namespace, which is specific to the current
+	// session.
+	UserScope
+)
+
+// String returns the name of the Scope.
+func (o Scope) String() string {
+	switch o {
+	case DefaultScope:
+		return "default"
+	case SystemScope:
+		return "system"
+	case UserScope:
+		return "user"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(o))
+	}
+}
+
+func (o Scope) validate() error {
+	switch o {
+	case DefaultScope, SystemScope, UserScope:
+		return nil
+	default:
+		return &ConfigureError{
+			reason: fmt.Sprintf("%s unknown scope - %s",
+				configureErrPrefix, o.String()),
+		}
+	}
+}
+
+// NewNamedMutex creates a new Mutex that is referenced by a logical name
+// rather than an OS specific resource, so that the same name works on
+// every operating system. It is shorthand for:
+//
+//	NewMutex(MutexConfig{Resource: name, Scope: scope})
+//
+// DefaultScope is treated as SystemScope. Refer to MutexConfig.Scope for
+// more information.
+func NewNamedMutex(name string, scope Scope) (Mutex, error) {
+	if scope == DefaultScope {
+		scope = SystemScope
+	}
+
+	return NewMutex(MutexConfig{
+		Resource: name,
+		Scope:    scope,
+	})
+}
+
+// resolveScope returns a copy of the MutexConfig whose resource is
+// mapped to the location of the Mutex in the configured Scope.
+// The MutexConfig is returned as is if it uses DefaultScope. The Scope's
+// directory is only created if create is true, so that functions which
+// merely query a Mutex have no side effects.
+func resolveScope(config MutexConfig, create bool) (MutexConfig, error) {
+	if config.Scope == DefaultScope {
+		return config, nil
+	}
+
+	safeName, err := sanitizeName(config.Resource)
+	if err != nil {
+		return MutexConfig{}, err
+	}
+
+	config.Resource, err = scopedResource(config.Scope, safeName, create)
+	if err != nil {
+		return MutexConfig{}, err
+	}
+
+	return config, nil
+}
+
+// sanitizeName maps a logical Mutex name to a string that is safe to use
+// as a file name or as the name of a Windows mutex object on any
+// operating system. Only ASCII letters, digits, '-', '_' and '.' (except
+// at the start) are kept. If any other character has to be replaced, or
+// if the name is too long, it is shortened and suffixed with a hash of
+// the original name so that different names remain different.
+func sanitizeName(name string) (string, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return "", &ConfigureError{
+			reason:     fmt.Sprintf("%s a name was not specified", configureErrPrefix),
+			noResource: true,
+		}
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '-', r == '_', r == '.' && i > 0:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	safe := b.String()
+	if safe == name && len(safe) <= maxNameLength {
+		return safe, nil
+	}
+
+	prefixLength := maxNameLength - nameHashLength - 1
+	if len(safe) > prefixLength {
+		safe = safe[:prefixLength]
+	}
+
+	sum := sha256.Sum256([]byte(name))
+
+	return safe + "-" + hex.EncodeToString(sum[:])[:nameHashLength], nil
+}
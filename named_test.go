@@ -0,0 +1,60 @@
+package ipcm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	safe, err := sanitizeName("my-app_1.0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if safe != "my-app_1.0" {
+		t.Fatalf("safe name should not be changed - got '%s'", safe)
+	}
+
+	names := []string{
+		`my/app`,
+		`my\app`,
+		`my:app`,
+		`..`,
+		strings.Repeat("a", maxNameLength+1),
+	}
+
+	seen := map[string]string{"my-app_1.0": "my-app_1.0"}
+
+	for _, name := range names {
+		safe, err := sanitizeName(name)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if strings.ContainsAny(safe, `/\:`) || strings.HasPrefix(safe, ".") {
+			t.Fatalf("name '%s' was not sanitized - got '%s'", name, safe)
+		}
+
+		if len(safe) > maxNameLength {
+			t.Fatalf("name '%s' is too long - got '%s'", name, safe)
+		}
+
+		if other, ok := seen[safe]; ok {
+			t.Fatalf("names '%s' and '%s' map to the same name '%s'", name, other, safe)
+		}
+		seen[safe] = name
+	}
+
+	_, err = sanitizeName(" ")
+	configErr, ok := err.(*ConfigureError)
+	if !ok || !configErr.ResourceNotSpecified() {
+		t.Fatalf("expected a resource not specified error - got %v", err)
+	}
+}
+
+func TestNewNamedMutex_InvalidScope(t *testing.T) {
+	_, err := NewNamedMutex("ipcm-test", Scope(42))
+	if _, ok := err.(*ConfigureError); !ok {
+		t.Fatalf("expected a configure error - got %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+)
+
+const (
+	namedMutexDirName = "ipcm"
+	namedMutexSuffix  = ".lock"
+
+	privateDirMode = 0700
+	sharedDirMode  = 0777 | os.ModeSticky
+	sharedLockMode = 0666
+)
+
+// systemLockDirs are the directories in which SystemScope lock files are
+// created, in order of preference. The temporary directory is used if
+// none of them exists.
+var systemLockDirs = []string{"/run/lock", "/var/lock"}
+
+// userRuntimeDirs is the directory that contains the runtime directory
+// of each user, named after the user's ID.
+var userRuntimeDirs = "/run/user"
+
+// scopedResource returns the path of the lock file with the specified
+// sanitized name in the Scope's directory. The directory is created if
+// create is true.
+func scopedResource(scope Scope, safeName string, create bool) (string, error) {
+	dir, err := scopeDir(scope, create)
+	if err != nil {
+		if configErr, ok := err.(*ConfigureError); ok {
+			return "", configErr
+		}
+
+		return "", &LockError{
+			reason:  fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
+			dirFail: true,
+		}
+	}
+
+	return path.Join(dir, safeName+namedMutexSuffix), nil
+}
+
+// scopeDir returns the directory in which lock files of the Scope are
+// created. If create is true, the directory is created if needed, and
+// is checked to be suitable for the Scope. Refer to SystemScope and
+// UserScope for the directories that are used.
+//
+// A *ConfigureError is returned if the current user is not allowed to
+// create the SystemScope directory. Falling back to another directory
+// would break mutual exclusion with processes run by other users.
+func scopeDir(scope Scope, create bool) (string, error) {
+	if scope == UserScope {
+		dir := userScopeDir()
+		if !create {
+			return dir, nil
+		}
+
+		return privateDir(dir)
+	}
+
+	dir := systemScopeDir()
+	if !create {
+		return dir, nil
+	}
+
+	dir, err := sharedDir(dir)
+	if os.IsPermission(err) {
+		return "", &ConfigureError{
+			reason: fmt.Sprintf("%s the current user cannot create the system scope directory - %s",
+				configureErrPrefix, err.Error()),
+		}
+	}
+
+	return dir, err
+}
+
+// userScopeDir returns the directory in which UserScope lock files are
+// created. The directory only depends on the current user's ID, rather
+// than on environment variables such as XDG_RUNTIME_DIR, so that every
+// process run by the user resolves the same directory (e.g., a cron job
+// and an interactive shell).
+func userScopeDir() string {
+	uid := os.Getuid()
+
+	runtimeDir := path.Join(userRuntimeDirs, strconv.Itoa(uid))
+	info, err := os.Lstat(runtimeDir)
+	if err == nil {
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if info.IsDir() && ok && int(stat.Uid) == uid {
+			return path.Join(runtimeDir, namedMutexDirName)
+		}
+	}
+
+	return path.Join(os.TempDir(), namedMutexDirName+"-"+strconv.Itoa(uid))
+}
+
+// systemScopeDir returns the directory in which SystemScope lock files
+// are created. The first of the systemLockDirs that exists is used, so
+// that every process resolves the same directory regardless of the user
+// that runs it.
+func systemScopeDir() string {
+	for _, dir := range systemLockDirs {
+		info, err := os.Stat(dir)
+		if err == nil && info.IsDir() {
+			return path.Join(dir, namedMutexDirName)
+		}
+	}
+
+	return path.Join(os.TempDir(), namedMutexDirName)
+}
+
+// privateDir creates a directory that is only accessible by the current
+// user. An existing directory is only used if it is owned by the current
+// user and is not accessible by anyone else.
+func privateDir(dir string) (string, error) {
+	err := os.Mkdir(dir, privateDirMode)
+	if err != nil && !os.IsExist(err) {
+		return "", err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("'%s' is not a directory that is private to the current user", dir)
+	}
+
+	return dir, nil
+}
+
+// sharedDir creates a directory that any user can create files in.
+// Like the temporary directory, the sticky bit prevents users from
+// removing each other's files. An existing directory is only used if it
+// is owned by root or the current user, and has the same mode, since
+// another user could otherwise control the files in it.
+func sharedDir(dir string) (string, error) {
+	err := os.Mkdir(dir, sharedDirMode)
+	if err == nil {
+		// The mode passed to mkdir(2) is subject to the umask.
+		err = os.Chmod(dir, sharedDirMode)
+		if err != nil {
+			return "", err
+		}
+	} else if !os.IsExist(err) {
+		return "", err
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || (stat.Uid != 0 && int(stat.Uid) != os.Getuid()) ||
+		info.Mode()&(os.ModePerm|os.ModeSticky) != sharedDirMode {
+		return "", fmt.Errorf("'%s' is not a shared directory owned by root or the current user", dir)
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,349 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewNamedMutex_UserScope(t *testing.T) {
+	env := setupTestEnv(t)
+	runtimeDir := setupUserRuntimeDir(env, t)
+
+	m, err := NewNamedMutex("build/cache", UserScope)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	safeName, _ := sanitizeName("build/cache")
+	expected := path.Join(runtimeDir, namedMutexDirName, safeName+namedMutexSuffix)
+
+	m.Lock()
+	defer m.Unlock()
+
+	held, err := isLockFileHeld(expected, flockMethod)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !held {
+		t.Fatalf("lock file '%s' should be held", expected)
+	}
+
+	info, err := os.Stat(path.Dir(expected))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if info.Mode().Perm() != privateDirMode {
+		t.Fatalf("directory should be private - got %s", info.Mode().String())
+	}
+}
+
+func TestUserScopeDir(t *testing.T) {
+	env := setupTestEnv(t)
+	runtimeDir := setupUserRuntimeDir(env, t)
+
+	// Processes run by the same user must resolve the same directory
+	// regardless of their environment.
+	original := os.Getenv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", env.mutexConfig.Resource+".other")
+	defer os.Setenv("XDG_RUNTIME_DIR", original)
+
+	expected := path.Join(runtimeDir, namedMutexDirName)
+	if dir := userScopeDir(); dir != expected {
+		t.Fatalf("expected directory '%s' - got '%s'", expected, dir)
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	// A runtime directory that belongs to another user must not be used.
+	err := os.Chown(runtimeDir, os.Getuid()+1, -1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected = path.Join(os.TempDir(), namedMutexDirName+"-"+strconv.Itoa(os.Getuid()))
+	if dir := userScopeDir(); dir != expected {
+		t.Fatalf("expected directory '%s' - got '%s'", expected, dir)
+	}
+}
+
+func TestNewNamedMutex_UserScopeRejectsSharedDir(t *testing.T) {
+	env := setupTestEnv(t)
+	runtimeDir := setupUserRuntimeDir(env, t)
+	err := os.Mkdir(path.Join(runtimeDir, namedMutexDirName), 0777)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = os.Chmod(path.Join(runtimeDir, namedMutexDirName), 0777)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = NewNamedMutex("build", UserScope)
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.FailedToCreateParentDirectory() {
+		t.Fatalf("expected a directory error - got %v", err)
+	}
+}
+
+func TestNewNamedMutex_SystemScope(t *testing.T) {
+	env := setupTestEnv(t)
+	lockDir := env.mutexConfig.Resource + ".lock"
+	err := os.Mkdir(lockDir, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(lockDir)
+
+	original := systemLockDirs
+	systemLockDirs = []string{path.Join(lockDir, "missing"), lockDir}
+	defer func() {
+		systemLockDirs = original
+	}()
+
+	a, err := NewNamedMutex("build", SystemScope)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	b, err := NewNamedMutex("build", SystemScope)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	a.Lock()
+	defer a.Unlock()
+
+	err = b.TimedTryLock(100 * time.Millisecond)
+	if err == nil {
+		b.Unlock()
+		t.Fatal("mutexes with the same name should exclude each other")
+	}
+
+	info, err := os.Stat(path.Join(lockDir, namedMutexDirName))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if info.Mode()&os.ModeSticky == 0 || info.Mode().Perm() != 0777 {
+		t.Fatalf("directory should be shared - got %s", info.Mode().String())
+	}
+
+	info, err = os.Stat(path.Join(lockDir, namedMutexDirName, "build"+namedMutexSuffix))
+	if err != nil {
+		t.Fatalf("lock file should exist - %s", err.Error())
+	}
+
+	if info.Mode().Perm() != sharedLockMode {
+		t.Fatalf("lock file should be accessible by all users - got %s", info.Mode().String())
+	}
+}
+
+func TestNewNamedMutex_SystemScopeNotWritable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can create the directory regardless of its permissions")
+	}
+
+	env := setupTestEnv(t)
+	lockDir := env.mutexConfig.Resource + ".lock"
+	err := os.Mkdir(lockDir, 0555)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(lockDir)
+
+	original := systemLockDirs
+	systemLockDirs = []string{lockDir}
+	defer func() {
+		systemLockDirs = original
+	}()
+
+	// Falling back to another directory would allow processes run by
+	// other users to hold the same mutex at the same time.
+	_, err = NewNamedMutex("build", SystemScope)
+	if _, ok := err.(*ConfigureError); !ok {
+		t.Fatalf("expected a *ConfigureError - got %v", err)
+	}
+}
+
+func TestNewNamedMutex_SystemScopeRejectsUnsafeDir(t *testing.T) {
+	env := setupTestEnv(t)
+	lockDir := env.mutexConfig.Resource + ".lock"
+	err := os.MkdirAll(path.Join(lockDir, namedMutexDirName), 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(lockDir)
+
+	original := systemLockDirs
+	systemLockDirs = []string{lockDir}
+	defer func() {
+		systemLockDirs = original
+	}()
+
+	// Without the sticky bit, the directory's owner could replace the
+	// lock files of other users.
+	_, err = NewNamedMutex("build", SystemScope)
+	if err == nil {
+		t.Fatal("a directory that is not shared should have been rejected")
+	}
+}
+
+func TestNewNamedMutex_SystemScopeSymlink(t *testing.T) {
+	env := setupTestEnv(t)
+	lockDir := env.mutexConfig.Resource + ".lock"
+	err := os.Mkdir(lockDir, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(lockDir)
+
+	original := systemLockDirs
+	systemLockDirs = []string{lockDir}
+	defer func() {
+		systemLockDirs = original
+	}()
+
+	_, err = sharedDir(path.Join(lockDir, namedMutexDirName))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	target := env.mutexConfig.Resource + ".target"
+	err = ioutil.WriteFile(target, nil, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.Remove(target)
+
+	err = os.Symlink(target, path.Join(lockDir, namedMutexDirName, "build"+namedMutexSuffix))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m, err := NewNamedMutex("build", SystemScope)
+	if err == nil {
+		m.Close()
+		t.Fatal("a lock file that is a symbolic link should have been rejected")
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("the symbolic link's target should not have been changed - got %s", info.Mode().String())
+	}
+}
+
+func TestNewMutex_Scope(t *testing.T) {
+	env := setupTestEnv(t)
+	runtimeDir := setupUserRuntimeDir(env, t)
+
+	config := MutexConfig{
+		Resource: "build",
+		Label:    "scope-test",
+		Scope:    UserScope,
+	}
+
+	m, err := NewMutex(config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.Lock()
+	defer m.Unlock()
+
+	held, err := isLockFileHeld(path.Join(runtimeDir, namedMutexDirName, "build"+namedMutexSuffix), flockMethod)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !held {
+		t.Fatal("lock file in the user's runtime directory should be held")
+	}
+
+	// Other functions resolve the resource the same way.
+	info, err := Owner(config)
+	if err != nil {
+		t.Fatalf("failed to get owner - %s", err.Error())
+	}
+
+	if info.Label != config.Label {
+		t.Fatalf("expected label '%s' - got '%s'", config.Label, info.Label)
+	}
+}
+
+func TestOwner_ScopeDoesNotCreateDir(t *testing.T) {
+	env := setupTestEnv(t)
+	runtimeDir := setupUserRuntimeDir(env, t)
+
+	config := MutexConfig{
+		Resource: "build",
+		Scope:    UserScope,
+	}
+
+	_, err := Owner(config)
+	if err == nil {
+		t.Fatal("getting the owner of a mutex that was never locked should have failed")
+	}
+
+	_, err = CurrentToken(config)
+	if err != nil {
+		t.Fatalf("failed to get token - %s", err.Error())
+	}
+
+	_, err = os.Stat(path.Join(runtimeDir, namedMutexDirName))
+	if !os.IsNotExist(err) {
+		t.Fatalf("querying a mutex should not create its directory - got %v", err)
+	}
+}
+
+func TestNewMutex_ScopeAbstractSocket(t *testing.T) {
+	_, err := NewMutex(MutexConfig{
+		Resource: "build",
+		Backend:  AbstractSocketBackend,
+		Scope:    UserScope,
+	})
+	configErr, ok := err.(*ConfigureError)
+	if !ok || !configErr.NotSupported() {
+		t.Fatalf("expected a not supported error - got %v", err)
+	}
+}
+
+// setupUserRuntimeDir creates a runtime directory for the current user
+// in the test data directory, and makes UserScope use it. It returns
+// the path of the runtime directory.
+func setupUserRuntimeDir(env testEnv, t *testing.T) string {
+	runtimeDirs := env.mutexConfig.Resource + ".runtime"
+	runtimeDir := path.Join(runtimeDirs, strconv.Itoa(os.Getuid()))
+	err := os.MkdirAll(runtimeDir, 0700)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	original := userRuntimeDirs
+	userRuntimeDirs = runtimeDirs
+
+	t.Cleanup(func() {
+		userRuntimeDirs = original
+		os.RemoveAll(runtimeDirs)
+	})
+
+	return runtimeDir
+}
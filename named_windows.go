@@ -0,0 +1,18 @@
+package ipcm
+
+// scopedResource returns the name of the mutex object with the specified
+// sanitized name. The Scope selects the kernel object namespace rather
+// than the name (refer to namespaceFor), so nothing is ever created.
+func scopedResource(scope Scope, safeName string, create bool) (string, error) {
+	return safeName, nil
+}
+
+// namespaceFor returns the kernel object namespace in which mutex
+// objects of the Scope are created.
+func namespaceFor(scope Scope) string {
+	if scope == UserScope {
+		return localPrefix
+	}
+
+	return globalPrefix
+}
@@ -0,0 +1,130 @@
+package ipcm
+
+import (
+	"context"
+	"time"
+)
+
+// Observer receives notifications about a Mutex's lock attempts. It can
+// be used to export metrics about wait times, hold times and contention.
+// Refer to MutexConfig.Observer for more information.
+//
+// Methods are called synchronously by the routine that locks or unlocks
+// the Mutex, so they should return quickly. An Observer shared by
+// several Mutexes must be safe for concurrent use.
+type Observer interface {
+	// LockStarted is called when a routine starts trying to lock the
+	// Mutex.
+	LockStarted(resource string)
+
+	// LockAcquired is called when a routine locks the Mutex. wait is
+	// the time since the attempt started, and retries is the number of
+	// failed OS mutex lock attempts that were retried according to the
+	// MutexConfig's RetryPolicy.
+	//
+	// Be advised that retries does not measure contention. Backends
+	// that block until the OS mutex is released (such as the default
+	// lock file backend on unix systems) wait without retrying, so
+	// retries is usually zero for them. Use wait to measure contention
+	// instead.
+	LockAcquired(resource string, wait time.Duration, retries int)
+
+	// LockTimedOut is called when a routine gives up on locking the
+	// Mutex because its timeout (or its context's deadline) elapsed.
+	// system is true if the routine timed out while waiting for the OS
+	// mutex (refer to LockError.SystemMutexLockTimedOut), and false if
+	// it timed out while waiting for the in-process mutex (refer to
+	// LockError.SyncMutexLockTimedOut).
+	//
+	// Attempts that fail for other reasons (e.g., because the context
+	// was cancelled) are not reported.
+	LockTimedOut(resource string, wait time.Duration, system bool)
+
+	// Unlocked is called when a routine unlocks the Mutex. hold is the
+	// time since the Mutex was locked.
+	Unlocked(resource string, hold time.Duration)
+}
+
+type retryCounterContextKey struct{}
+
+// lockAttempt tracks a single lock attempt on behalf of an Observer.
+// The zero value does not notify anyone.
+type lockAttempt struct {
+	observer Observer
+	resource string
+	start    time.Time
+	retries  *int
+}
+
+// startLockAttempt notifies the MutexConfig's Observer that a lock
+// attempt started. The returned context must be used for the attempt
+// so that its retries are counted.
+func startLockAttempt(ctx context.Context, config MutexConfig) (context.Context, lockAttempt) {
+	if config.Observer == nil {
+		return ctx, lockAttempt{}
+	}
+
+	attempt := lockAttempt{
+		observer: config.Observer,
+		resource: config.Resource,
+		start:    time.Now(),
+		retries:  new(int),
+	}
+
+	attempt.observer.LockStarted(attempt.resource)
+
+	return context.WithValue(ctx, retryCounterContextKey{}, attempt.retries), attempt
+}
+
+// acquired notifies the Observer that the Mutex was locked. It returns
+// the time at which the Mutex was locked.
+func (o lockAttempt) acquired() time.Time {
+	now := time.Now()
+
+	if o.observer != nil {
+		o.observer.LockAcquired(o.resource, now.Sub(o.start), *o.retries)
+	}
+
+	return now
+}
+
+// failed notifies the Observer if the lock attempt failed because it
+// timed out. system indicates whether a context.DeadlineExceeded error
+// occurred while waiting for the OS mutex. Timeouts reported as
+// a *LockError are classified using its predicates instead.
+func (o lockAttempt) failed(err error, system bool) {
+	if o.observer == nil {
+		return
+	}
+
+	switch e := err.(type) {
+	case *LockError:
+		if !e.syncTimeout && !e.systemTimeout {
+			return
+		}
+		system = e.systemTimeout
+	default:
+		if err != context.DeadlineExceeded {
+			return
+		}
+	}
+
+	o.observer.LockTimedOut(o.resource, time.Since(o.start), system)
+}
+
+// notifyUnlocked notifies the MutexConfig's Observer that the Mutex,
+// which was locked at the specified time, was unlocked.
+func notifyUnlocked(config MutexConfig, lockedAt time.Time) {
+	if config.Observer != nil {
+		config.Observer.Unlocked(config.Resource, time.Since(lockedAt))
+	}
+}
+
+// countRetry increments the retry counter carried by the context, if
+// any.
+func countRetry(ctx context.Context) {
+	retries, ok := ctx.Value(retryCounterContextKey{}).(*int)
+	if ok {
+		*retries++
+	}
+}
@@ -0,0 +1,142 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingObserver is an Observer that records its notifications.
+type recordingObserver struct {
+	mutex   sync.Mutex
+	events  []string
+	retries int
+}
+
+func (o *recordingObserver) record(event string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.events = append(o.events, event)
+}
+
+func (o *recordingObserver) recorded() []string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return append([]string(nil), o.events...)
+}
+
+func (o *recordingObserver) LockStarted(resource string) {
+	o.record("started")
+}
+
+func (o *recordingObserver) LockAcquired(resource string, wait time.Duration, retries int) {
+	o.mutex.Lock()
+	o.retries = retries
+	o.mutex.Unlock()
+
+	o.record("acquired")
+}
+
+func (o *recordingObserver) LockTimedOut(resource string, wait time.Duration, system bool) {
+	o.record(fmt.Sprintf("timed out (system: %t)", system))
+}
+
+func (o *recordingObserver) Unlocked(resource string, hold time.Duration) {
+	o.record("unlocked")
+}
+
+func TestNewMutex_Observer(t *testing.T) {
+	env := setupTestEnv(t)
+	observer := &recordingObserver{}
+	env.mutexConfig.Observer = observer
+
+	a, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	b, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	a.Lock()
+
+	// The in-process mutex is held.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = a.LockContext(ctx)
+	if err == nil {
+		t.Fatal("lock should have timed out")
+	}
+
+	// The OS mutex is held.
+	err = b.TimedTryLock(100 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock should have timed out")
+	}
+
+	a.Unlock()
+
+	expected := []string{
+		"started",
+		"acquired",
+		"started",
+		"timed out (system: false)",
+		"started",
+		"timed out (system: true)",
+		"unlocked",
+	}
+
+	events := observer.recorded()
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v - got %v", expected, events)
+	}
+}
+
+func TestNewMutex_ObserverRetries(t *testing.T) {
+	env := setupTestEnv(t)
+	observer := &recordingObserver{}
+	env.mutexConfig.Observer = observer
+	env.mutexConfig.Backend = AbstractSocketBackend
+
+	a, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		if configErr, ok := err.(*ConfigureError); ok && configErr.NotSupported() {
+			t.Skip(err.Error())
+		}
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	b, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	a.Lock()
+	time.AfterFunc(300*time.Millisecond, a.Unlock)
+
+	// The abstract socket backend polls, so waiting for the Mutex
+	// involves retries.
+	err = b.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	b.Unlock()
+
+	observer.mutex.Lock()
+	defer observer.mutex.Unlock()
+
+	if observer.retries == 0 {
+		t.Fatal("retries should have been counted")
+	}
+}
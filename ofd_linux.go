@@ -0,0 +1,66 @@
+package ipcm
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ofdSupported is true if the operating system supports OFD locks.
+const ofdSupported = true
+
+// ofdLock applies a flock(2) style lock operation to a byte range of
+// the file descriptor using an open file description (OFD) lock. A
+// length of zero means the range extends to the end of the file
+// (including any bytes appended later).
+func ofdLock(fd int, how int, start int64, length int64) error {
+	lock := unix.Flock_t{
+		Whence: 0,
+		Start:  start,
+		Len:    length,
+	}
+
+	switch how &^ unix.LOCK_NB {
+	case unix.LOCK_SH:
+		lock.Type = unix.F_RDLCK
+	case unix.LOCK_EX:
+		lock.Type = unix.F_WRLCK
+	case unix.LOCK_UN:
+		lock.Type = unix.F_UNLCK
+	default:
+		return unix.EINVAL
+	}
+
+	cmd := unix.F_OFD_SETLKW
+	if how&unix.LOCK_NB != 0 {
+		cmd = unix.F_OFD_SETLK
+	}
+
+	err := unix.FcntlFlock(uintptr(fd), cmd, &lock)
+	if err == unix.EAGAIN || err == unix.EACCES {
+		return unix.EWOULDBLOCK
+	}
+
+	return err
+}
+
+// ofdIsLocked returns true if a byte range of the file descriptor
+// cannot be locked using the specified lock operation because another
+// open file description holds a conflicting lock.
+func ofdIsLocked(fd int, how int, start int64, length int64) (bool, error) {
+	lock := unix.Flock_t{
+		Type:   unix.F_WRLCK,
+		Whence: 0,
+		Start:  start,
+		Len:    length,
+	}
+
+	if how&^unix.LOCK_NB == unix.LOCK_SH {
+		lock.Type = unix.F_RDLCK
+	}
+
+	err := unix.FcntlFlock(uintptr(fd), unix.F_OFD_GETLK, &lock)
+	if err != nil {
+		return false, err
+	}
+
+	return lock.Type != unix.F_UNLCK, nil
+}
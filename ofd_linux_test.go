@@ -0,0 +1,64 @@
+package ipcm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewMutex_OFDBackend(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Backend = OFDBackend
+
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	err = m.TimedTryLock(500 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while another process holds the mutex")
+	}
+
+	info, err := Owner(env.mutexConfig)
+	if err != nil {
+		t.Fatalf("failed to get owner - %s", err.Error())
+	}
+
+	if info.PID != testHarness.Process.Pid {
+		t.Fatalf("owner pid should be %d - got %d", testHarness.Process.Pid, info.PID)
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	err = m.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the other process exited - %s", err.Error())
+	}
+
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	err = other.TimedTryLock(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while another mutex in this process holds it")
+	}
+
+	m.Unlock()
+
+	err = other.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the mutex was unlocked - %s", err.Error())
+	}
+	other.Unlock()
+}
@@ -0,0 +1,20 @@
+// +build !windows,!linux
+
+package ipcm
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// ofdSupported is true if the operating system supports OFD locks.
+const ofdSupported = false
+
+// ofdLock is not supported on this operating system.
+func ofdLock(fd int, how int, start int64, length int64) error {
+	return unix.ENOTSUP
+}
+
+// ofdIsLocked is not supported on this operating system.
+func ofdIsLocked(fd int, how int, start int64, length int64) (bool, error) {
+	return false, unix.ENOTSUP
+}
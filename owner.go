@@ -0,0 +1,85 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ownerTimeFormat = "15:04:05"
+)
+
+// OwnerInfo describes the holder of a Mutex.
+type OwnerInfo struct {
+	// PID is the process ID of the holder.
+	PID int `json:"pid"`
+
+	// Hostname is the name of the host the holder is running on.
+	Hostname string `json:"hostname"`
+
+	// Executable is the name of the holder's executable.
+	Executable string `json:"executable"`
+
+	// Label is the label supplied by the holder, either through
+	// MutexConfig.Label or WithLabel.
+	Label string `json:"label,omitempty"`
+
+	// AcquiredAt is when the holder locked the Mutex.
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// String returns a human readable description of the owner.
+// For example:
+//
+//	pid 4312 (reindexer) since 10:02:11
+func (o OwnerInfo) String() string {
+	name := o.Label
+	if len(name) == 0 {
+		name = o.Executable
+	}
+
+	return fmt.Sprintf("pid %d (%s) since %s",
+		o.PID, name, o.AcquiredAt.Format(ownerTimeFormat))
+}
+
+// newOwnerInfo returns an OwnerInfo describing the current process.
+func newOwnerInfo(label string) OwnerInfo {
+	info := OwnerInfo{
+		PID:        os.Getpid(),
+		Label:      label,
+		AcquiredAt: time.Now(),
+	}
+
+	info.Hostname, _ = os.Hostname()
+
+	exePath, err := os.Executable()
+	if err == nil {
+		info.Executable = filepath.Base(exePath)
+	}
+
+	return info
+}
+
+type labelContextKey struct{}
+
+// WithLabel returns a copy of the parent context that carries the
+// specified label. The label is recorded as part of the Mutex's
+// OwnerInfo when the context is passed to LockContext. It overrides
+// MutexConfig.Label.
+func WithLabel(parent context.Context, label string) context.Context {
+	return context.WithValue(parent, labelContextKey{}, label)
+}
+
+// labelFromContext returns the label carried by the context, or the
+// fallback if the context does not carry one.
+func labelFromContext(ctx context.Context, fallback string) string {
+	label, ok := ctx.Value(labelContextKey{}).(string)
+	if !ok {
+		return fallback
+	}
+
+	return label
+}
@@ -0,0 +1,245 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ownerFileSuffix = ".owner"
+)
+
+// Owner returns information about the current holder of the Mutex
+// referenced by the MutexConfig. A *QueryError is returned if the Mutex
+// is not currently held, or if the holder did not record its
+// information.
+//
+// On unix systems, the information is stored in a file whose path is
+// the resource's path suffixed with '.owner'.
+func Owner(config MutexConfig) (OwnerInfo, error) {
+	config, err := validateUnixConfig(config, false)
+	if err != nil {
+		return OwnerInfo{}, err
+	}
+
+	if !usesLockFile(config) {
+		return OwnerInfo{}, &QueryError{
+			reason: fmt.Sprintf("%s owner information is not recorded by the %s backend",
+				queryErrPrefix, config.Backend.String()),
+			notSupported: true,
+		}
+	}
+
+	return readOwnerInfo(config)
+}
+
+// readOwnerInfo reads the owner file for the configured resource. The
+// owner file is only trusted if the resource file is actually locked
+// (or, in lease mode, if the lease has not expired).
+func readOwnerInfo(config MutexConfig) (OwnerInfo, error) {
+	var held bool
+	var err error
+	if config.LeaseTTL > 0 {
+		held, err = isLeaseHeld(config.Resource)
+	} else {
+		held, err = isLockFileHeld(config.Resource, lockMethodFor(config))
+	}
+	if err != nil {
+		return OwnerInfo{}, &QueryError{
+			reason: fmt.Sprintf("%s %s", queryErrPrefix, err.Error()),
+		}
+	}
+
+	if !held {
+		return OwnerInfo{}, &QueryError{
+			reason:  fmt.Sprintf("%s the mutex is not held", queryErrPrefix),
+			notHeld: true,
+		}
+	}
+
+	raw, err := ioutil.ReadFile(config.Resource + ownerFileSuffix)
+	if err == nil && len(raw) == 0 {
+		err = fmt.Errorf("the owner file is empty")
+	}
+	if err != nil {
+		return OwnerInfo{}, &QueryError{
+			reason: fmt.Sprintf("%s the holder did not record its information - %s",
+				queryErrPrefix, err.Error()),
+			noOwnerInfo: true,
+		}
+	}
+
+	// The owner file is overwritten in place, so a shorter record may
+	// be followed by the remainder of the previous one for a moment.
+	var info OwnerInfo
+	err = json.NewDecoder(bytes.NewReader(raw)).Decode(&info)
+	if err != nil {
+		return OwnerInfo{}, &QueryError{
+			reason: fmt.Sprintf("%s failed to parse owner information - %s",
+				queryErrPrefix, err.Error()),
+			noOwnerInfo: true,
+		}
+	}
+
+	// The record may have been left behind by a previous holder that
+	// exited without removing it, e.g., if the current holder failed
+	// to record its own information.
+	hostname, _ := os.Hostname()
+	if info.Hostname == hostname && !isProcessAlive(info.PID) {
+		return OwnerInfo{}, &QueryError{
+			reason: fmt.Sprintf("%s the recorded holder (pid %d) is no longer running",
+				queryErrPrefix, info.PID),
+			noOwnerInfo: true,
+		}
+	}
+
+	return info, nil
+}
+
+// isLockFileHeld returns true if the lock file is locked exclusively by
+// a process using the specified lockMethod.
+func isLockFileHeld(resource string, method lockMethod) (bool, error) {
+	f, err := os.Open(resource)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+
+	if method == ofdMethod {
+		return ofdIsLocked(int(f.Fd()), unix.LOCK_SH, 0, 0)
+	}
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+	switch err {
+	case nil:
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		return false, nil
+	case unix.EWOULDBLOCK:
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
+// writeOwnerInfo records the OwnerInfo for the specified resource.
+//
+// The owner file is overwritten in place rather than replaced, because
+// the sticky bit of a shared directory (refer to SystemScope) prevents
+// users from replacing each other's files. A shared owner file is
+// writable by all users, like the lock file.
+func writeOwnerInfo(resource string, info OwnerInfo, shared bool) error {
+	raw, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	ownerPath := resource + ownerFileSuffix
+
+	var f *os.File
+	if shared {
+		f, err = openSharedFile(ownerPath, os.O_WRONLY)
+	} else {
+		f, err = os.OpenFile(ownerPath, os.O_WRONLY|os.O_CREATE, lockMode)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteAt(raw, 0)
+	if err != nil {
+		return err
+	}
+
+	return f.Truncate(int64(len(raw)))
+}
+
+// replaceFile writes the data to a temporary file which then replaces
+// the specified file so that readers never see partially written data.
+func replaceFile(filePath string, data []byte) error {
+	return writeAndRename(filePath, data, false)
+}
+
+// replaceFileDurably is like replaceFile, but the data and the rename
+// are flushed to the storage device before it returns, so that the new
+// contents survive a crash.
+func replaceFileDurably(filePath string, data []byte) error {
+	return writeAndRename(filePath, data, true)
+}
+
+func writeAndRename(filePath string, data []byte, durable bool) error {
+	f, err := ioutil.TempFile(path.Dir(filePath), path.Base(filePath)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(lockMode)
+	}
+	if err == nil && durable {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), filePath)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if durable {
+		return syncDir(path.Dir(filePath))
+	}
+
+	return nil
+}
+
+// syncDir flushes the directory's entries to the storage device.
+func syncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// removeOwnerInfo removes the owner file for the specified resource.
+// A shared owner file may belong to another user, in which case it
+// cannot be removed. It is emptied instead.
+func removeOwnerInfo(resource string, shared bool) {
+	ownerPath := resource + ownerFileSuffix
+
+	if !shared {
+		os.Remove(ownerPath)
+		return
+	}
+
+	f, err := os.OpenFile(ownerPath, os.O_WRONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	f.Truncate(0)
+}
@@ -0,0 +1,194 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOwner(t *testing.T) {
+	env := setupTestEnv(t)
+	testHarness := startIdleTestHarness(env, testHarnessOptions{
+		config:      env.mutexConfig,
+		loopForever: true,
+		label:       "reindexer",
+	}, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	info, err := Owner(env.mutexConfig)
+	if err != nil {
+		t.Fatalf("failed to get owner - %s", err.Error())
+	}
+
+	if info.PID != testHarness.Process.Pid {
+		t.Fatalf("owner pid should be %d - got %d", testHarness.Process.Pid, info.PID)
+	}
+
+	if info.Label != "reindexer" {
+		t.Fatalf("owner label should be 'reindexer' - got '%s'", info.Label)
+	}
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = m.TimedTryLock(100 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed")
+	}
+
+	expected := "held by pid " + strconv.Itoa(testHarness.Process.Pid) + " (reindexer)"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("lock error should contain '%s' - got '%s'", expected, err.Error())
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	// The owner file left behind by the killed process must not be
+	// trusted.
+	_, err = Owner(env.mutexConfig)
+	queryErr, ok := err.(*QueryError)
+	if !ok || !queryErr.NotHeld() {
+		t.Fatalf("expected a not held error - got %v", err)
+	}
+}
+
+func TestOwner_WithLabel(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Label = "default"
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = m.LockContext(WithLabel(context.Background(), "worker-1"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	info, err := Owner(env.mutexConfig)
+	if err != nil {
+		t.Fatalf("failed to get owner - %s", err.Error())
+	}
+
+	if info.PID != os.Getpid() {
+		t.Fatalf("owner pid should be %d - got %d", os.Getpid(), info.PID)
+	}
+
+	if info.Label != "worker-1" {
+		t.Fatalf("owner label should be 'worker-1' - got '%s'", info.Label)
+	}
+
+	m.Unlock()
+
+	_, err = Owner(env.mutexConfig)
+	if err == nil {
+		t.Fatal("getting the owner of an unlocked mutex should have failed")
+	}
+}
+
+func TestOwner_StaleRecord(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.Lock()
+	defer m.Unlock()
+
+	exited := exec.Command("true")
+	err = exited.Run()
+	if err != nil {
+		t.Fatalf("failed to run process - %s", err.Error())
+	}
+
+	// The holder may fail to record its information, in which case the
+	// record of a previous holder is left behind.
+	stale := newOwnerInfo("previous")
+	stale.PID = exited.ProcessState.Pid()
+	err = writeOwnerInfo(env.mutexConfig.Resource, stale, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = Owner(env.mutexConfig)
+	queryErr, ok := err.(*QueryError)
+	if !ok || !queryErr.OwnerNotRecorded() {
+		t.Fatalf("expected an owner not recorded error - got %v", err)
+	}
+}
+
+func TestOwner_SystemScope(t *testing.T) {
+	env := setupTestEnv(t)
+	lockDir := env.mutexConfig.Resource + ".lock"
+	err := os.Mkdir(lockDir, 0755)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(lockDir)
+
+	original := systemLockDirs
+	systemLockDirs = []string{lockDir}
+	defer func() {
+		systemLockDirs = original
+	}()
+
+	config := MutexConfig{
+		Resource: "build",
+		Label:    "system-scope",
+		Scope:    SystemScope,
+	}
+
+	m, err := NewMutex(config)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.Lock()
+
+	info, err := Owner(config)
+	if err != nil {
+		m.Unlock()
+		t.Fatalf("failed to get owner - %s", err.Error())
+	}
+
+	if info.Label != config.Label {
+		m.Unlock()
+		t.Fatalf("expected label '%s' - got '%s'", config.Label, info.Label)
+	}
+
+	m.Unlock()
+
+	// Other users must be able to overwrite the record, since they
+	// cannot replace or remove it.
+	ownerPath := path.Join(lockDir, namedMutexDirName, "build"+namedMutexSuffix+ownerFileSuffix)
+	stat, err := os.Stat(ownerPath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if stat.Mode().Perm() != sharedLockMode {
+		t.Fatalf("owner file should be writable by all users - got %s", stat.Mode().String())
+	}
+
+	if stat.Size() != 0 {
+		t.Fatalf("owner file should have been emptied - got %d bytes", stat.Size())
+	}
+}
@@ -0,0 +1,18 @@
+package ipcm
+
+import (
+	"fmt"
+)
+
+// Owner returns information about the current holder of the Mutex
+// referenced by the MutexConfig.
+//
+// Owner information is not currently recorded on Windows. A *QueryError
+// is always returned.
+func Owner(config MutexConfig) (OwnerInfo, error) {
+	return OwnerInfo{}, &QueryError{
+		reason: fmt.Sprintf("%s owner information is not supported on this operating system",
+			queryErrPrefix),
+		notSupported: true,
+	}
+}
@@ -0,0 +1,48 @@
+package ipcm
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// MaxPriority is the highest priority of a lock attempt. Higher
+	// priorities are reduced to MaxPriority.
+	MaxPriority = 1000
+
+	// MinPriority is the lowest priority of a lock attempt. Lower
+	// priorities are raised to MinPriority.
+	MinPriority = -MaxPriority
+
+	defaultPriorityAging = time.Second
+)
+
+type priorityContextKey struct{}
+
+// WithPriority returns a copy of the parent context that carries the
+// specified priority. When the context is passed to LockContext,
+// waiters with a higher priority are preferred over waiters with
+// a lower priority. The default priority is zero.
+//
+// Priorities only take effect when MutexConfig.Fair is enabled. Refer to
+// MutexConfig.PriorityAging for details about how waiters with a lower
+// priority are protected from starvation.
+func WithPriority(parent context.Context, priority int) context.Context {
+	return context.WithValue(parent, priorityContextKey{}, priority)
+}
+
+// priorityFromContext returns the priority carried by the context,
+// limited to the range of MinPriority to MaxPriority.
+func priorityFromContext(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityContextKey{}).(int)
+
+	if priority > MaxPriority {
+		return MaxPriority
+	}
+
+	if priority < MinPriority {
+		return MinPriority
+	}
+
+	return priority
+}
@@ -0,0 +1,116 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewMutex_Priority(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+	env.mutexConfig.PriorityAging = 100 * time.Millisecond
+
+	low, high, order := runPriorityOrderTest(env, 200*time.Millisecond, t)
+	if order[0] != high {
+		t.Fatalf("high priority process %s should have locked the mutex before low priority process %s - order: %v",
+			high, low, order)
+	}
+}
+
+func TestNewMutex_PriorityStarvationBound(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Fair = true
+	env.mutexConfig.PriorityAging = 100 * time.Millisecond
+
+	// The high priority process starts waiting well after the low
+	// priority process' priority aging is exceeded.
+	low, high, order := runPriorityOrderTest(env, 1500*time.Millisecond, t)
+	if order[0] != low {
+		t.Fatalf("low priority process %s should not have been passed by high priority process %s - order: %v",
+			low, high, order)
+	}
+}
+
+// runPriorityOrderTest locks the mutex, and then starts a low priority
+// test harness process. After the specified delay, it starts a high
+// priority test harness process. Once both processes are waiting for
+// the mutex, the mutex is unlocked. It returns the PIDs of the low and
+// high priority processes, and the order in which they locked the mutex.
+func runPriorityOrderTest(env testEnv, delay time.Duration, t *testing.T) (string, string, []string) {
+	orderFilePath := env.mutexConfig.Resource + ".order"
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			m.Unlock()
+		}
+	}()
+
+	err = ioutil.WriteFile(orderFilePath, nil, 0600)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	options := testHarnessOptions{
+		config:        env.mutexConfig,
+		orderFilePath: orderFilePath,
+		iterations:    1,
+	}
+
+	low := compileTestHarness(env, options, t)
+
+	options.priority = 10
+	high := exec.Command(low.Path, options.args(t)...)
+
+	err = low.Start()
+	if err != nil {
+		t.Fatalf("test harness failed to start - %s", err.Error())
+	}
+	defer low.Process.Kill()
+
+	time.Sleep(delay)
+
+	err = high.Start()
+	if err != nil {
+		t.Fatalf("test harness failed to start - %s", err.Error())
+	}
+	defer high.Process.Kill()
+
+	// Give the high priority process a chance to take its ticket.
+	time.Sleep(300 * time.Millisecond)
+
+	m.Unlock()
+	locked = false
+
+	for _, harness := range []*exec.Cmd{low, high} {
+		err := harness.Wait()
+		if err != nil {
+			t.Fatalf("test harness failed - %s", err.Error())
+		}
+	}
+
+	raw, err := ioutil.ReadFile(orderFilePath)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	order := strings.Fields(string(raw))
+	if len(order) != 2 {
+		t.Fatalf("expected 2 entries in the order file - got %v", order)
+	}
+
+	return strconv.Itoa(low.Process.Pid), strconv.Itoa(high.Process.Pid), order
+}
@@ -0,0 +1,82 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+)
+
+// RangeLocker locks byte ranges of a file across process boundaries.
+// Unlike a Mutex, which serializes access to an entire resource,
+// a RangeLocker allows routines (in any process) that work on disjoint
+// regions of the same file to proceed in parallel.
+//
+// A range is identified by its offset and length, in bytes. A length of
+// zero means the range extends to the end of the file, including any
+// bytes appended later. Ranges that overlap conflict with each other
+// unless both are locked for reading (i.e., shared).
+//
+// Every locked range is backed by its own file descriptor. As a result,
+// overlapping ranges conflict even when they are locked by the same
+// RangeLocker, and ranges held by a process are released automatically
+// if the process exits.
+//
+// A RangeLocker is safe for concurrent use.
+type RangeLocker interface {
+	// LockRange locks the range exclusively, blocking until it is
+	// available or until the provided context.Context is done. If the
+	// context is done first, ctx.Err() is returned.
+	LockRange(ctx context.Context, offset int64, length int64) error
+
+	// TryLockRange attempts to lock the range exclusively without
+	// blocking. It returns false if a conflicting range is locked.
+	TryLockRange(offset int64, length int64) (bool, error)
+
+	// RLockRange locks the range for reading, blocking until no
+	// conflicting range is locked or until the provided context.Context
+	// is done. If the context is done first, ctx.Err() is returned.
+	RLockRange(ctx context.Context, offset int64, length int64) error
+
+	// TryRLockRange attempts to lock the range for reading without
+	// blocking. It returns false if a conflicting range is locked.
+	TryRLockRange(offset int64, length int64) (bool, error)
+
+	// UnlockRange unlocks a range previously locked by this RangeLocker
+	// using the same offset and length. Ranges cannot be partially
+	// unlocked. This call will panic if the range is not locked.
+	UnlockRange(offset int64, length int64)
+
+	// Close marks the RangeLocker as closed. All ranges must be
+	// unlocked first. Subsequent attempts to lock ranges fail.
+	Close() error
+}
+
+// byteRange is a range of bytes in a file.
+type byteRange struct {
+	offset int64
+	length int64
+}
+
+func (o byteRange) String() string {
+	if o.length == 0 {
+		return fmt.Sprintf("[%d, EOF)", o.offset)
+	}
+
+	return fmt.Sprintf("[%d, %d)", o.offset, o.offset+o.length)
+}
+
+// newByteRange validates and creates a byteRange.
+func newByteRange(offset int64, length int64) (byteRange, error) {
+	r := byteRange{
+		offset: offset,
+		length: length,
+	}
+
+	if offset < 0 || length < 0 || offset+length < offset {
+		return r, &LockError{
+			reason: fmt.Sprintf("%s the byte range is invalid - offset %d, length %d",
+				unableToAcquirePrefix, offset, length),
+		}
+	}
+
+	return r, nil
+}
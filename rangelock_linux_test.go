@@ -0,0 +1,214 @@
+package ipcm
+
+import (
+	"context"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestRangeLockers(t *testing.T) (RangeLocker, RangeLocker) {
+	env := setupTestEnv(t)
+	env.mutexConfig.Resource = path.Join(env.dataDirPath, "data")
+
+	a, err := NewRangeLocker(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	b, err := NewRangeLocker(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	return a, b
+}
+
+func TestRangeLocker_DisjointRanges(t *testing.T) {
+	a, b := newTestRangeLockers(t)
+
+	err := a.LockRange(context.Background(), 0, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	locked, err := b.TryLockRange(100, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !locked {
+		t.Fatal("a disjoint range should have been locked")
+	}
+
+	locked, err = b.TryLockRange(50, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if locked {
+		t.Fatal("an overlapping range should not have been locked")
+	}
+
+	locked, err = a.TryLockRange(99, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if locked {
+		t.Fatal("a range overlapping a range held by the same RangeLocker should not have been locked")
+	}
+
+	a.UnlockRange(0, 100)
+	b.UnlockRange(100, 100)
+
+	locked, err = a.TryLockRange(0, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !locked {
+		t.Fatal("the whole file should have been locked once all ranges were unlocked")
+	}
+
+	a.UnlockRange(0, 0)
+}
+
+func TestRangeLocker_SharedRanges(t *testing.T) {
+	a, b := newTestRangeLockers(t)
+
+	err := a.RLockRange(context.Background(), 0, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	locked, err := b.TryRLockRange(50, 100)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !locked {
+		t.Fatal("overlapping shared ranges should not conflict")
+	}
+
+	locked, err = b.TryLockRange(0, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if locked {
+		t.Fatal("an exclusive range should conflict with a shared range")
+	}
+
+	a.UnlockRange(0, 100)
+	b.UnlockRange(50, 100)
+}
+
+func TestRangeLocker_LockRangeWaits(t *testing.T) {
+	a, b := newTestRangeLockers(t)
+
+	err := a.LockRange(context.Background(), 10, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = b.LockRange(ctx, 15, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v - got %v", context.DeadlineExceeded, err)
+	}
+
+	time.AfterFunc(100*time.Millisecond, func() {
+		a.UnlockRange(10, 10)
+	})
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = b.LockRange(ctx, 15, 1)
+	if err != nil {
+		t.Fatalf("range should have been locked once it was released - %s", err.Error())
+	}
+
+	b.UnlockRange(15, 1)
+}
+
+func TestRangeLocker_CancelledWaitsAreReused(t *testing.T) {
+	a, b := newTestRangeLockers(t)
+
+	err := a.LockRange(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	routines := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		err = b.LockRange(ctx, 0, 10)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected %v - got %v", context.DeadlineExceeded, err)
+		}
+	}
+
+	// The cancelled lock attempts must share a single waiter.
+	if leaked := runtime.NumGoroutine() - routines; leaked > 1 {
+		t.Fatalf("cancelled lock attempts left %d routines behind", leaked)
+	}
+
+	a.UnlockRange(0, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = b.LockRange(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("range should have been locked once it was released - %s", err.Error())
+	}
+
+	b.UnlockRange(0, 10)
+}
+
+func TestRangeLocker_InvalidRange(t *testing.T) {
+	a, _ := newTestRangeLockers(t)
+
+	_, err := a.TryLockRange(-1, 10)
+	if err == nil {
+		t.Fatal("a negative offset should be rejected")
+	}
+
+	err = a.LockRange(context.Background(), 0, -10)
+	if err == nil {
+		t.Fatal("a negative length should be rejected")
+	}
+}
+
+func TestRangeLocker_Close(t *testing.T) {
+	a, _ := newTestRangeLockers(t)
+
+	err := a.LockRange(context.Background(), 0, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = a.Close()
+	if closeErr, ok := err.(*CloseError); !ok || !closeErr.StillLocked() {
+		t.Fatalf("close should fail while a range is locked - got %v", err)
+	}
+
+	a.UnlockRange(0, 1)
+
+	err = a.Close()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = a.LockRange(context.Background(), 0, 1)
+	if lockErr, ok := err.(*LockError); !ok || !lockErr.MutexClosed() {
+		t.Fatalf("lock should fail once the RangeLocker is closed - got %v", err)
+	}
+}
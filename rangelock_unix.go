@@ -0,0 +1,337 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// rangeWait identifies a wait for a range using a lock operation.
+type rangeWait struct {
+	r   byteRange
+	how int
+}
+
+// pendingRangeWait is an abandoned wait for a range, along with the file
+// descriptor that it waits with.
+type pendingRangeWait struct {
+	file   *os.File
+	waiter *lockWaiter
+}
+
+// unixRangeLocker keeps at most one abandoned waiter per range and lock
+// operation in pending. Subsequent lock attempts adopt it rather than
+// pile up blocked system calls (refer to lockWaiter).
+type unixRangeLocker struct {
+	mutex   sync.Mutex
+	held    map[byteRange][]*os.File
+	pending map[rangeWait]pendingRangeWait
+	closed  bool
+	config  MutexConfig
+}
+
+func (o *unixRangeLocker) LockRange(ctx context.Context, offset int64, length int64) error {
+	return o.lockRange(ctx, offset, length, unix.LOCK_EX)
+}
+
+func (o *unixRangeLocker) TryLockRange(offset int64, length int64) (bool, error) {
+	return o.tryLockRange(offset, length, unix.LOCK_EX)
+}
+
+func (o *unixRangeLocker) RLockRange(ctx context.Context, offset int64, length int64) error {
+	return o.lockRange(ctx, offset, length, unix.LOCK_SH)
+}
+
+func (o *unixRangeLocker) TryRLockRange(offset int64, length int64) (bool, error) {
+	return o.tryLockRange(offset, length, unix.LOCK_SH)
+}
+
+// lockRange locks the range using the specified lock operation. If the
+// range conflicts with a locked range, a blocking call is used so that
+// the kernel hands over the range as soon as it is released.
+func (o *unixRangeLocker) lockRange(ctx context.Context, offset int64, length int64, how int) error {
+	r, err := newByteRange(offset, length)
+	if err != nil {
+		return err
+	}
+
+	key := rangeWait{
+		r:   r,
+		how: how,
+	}
+
+	file, waiter := o.adoptPending(key)
+	if waiter == nil {
+		var locked bool
+		r, file, locked, err = o.tryLockRangeFile(offset, length, how)
+		if err != nil || locked {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			// No point in waiting for the range.
+			file.Close()
+			return ctx.Err()
+		}
+
+		fd := int(file.Fd())
+		waiter = startLockWaiter(file, func(how int) error {
+			return ofdLock(fd, how, r.offset, r.length)
+		}, how)
+	}
+
+	err = waiter.wait(ctx)
+	if err == nil {
+		return o.addHeld(r, file)
+	}
+
+	if err == ctx.Err() {
+		o.abandon(key, file, waiter)
+		return err
+	}
+
+	file.Close()
+
+	return newRangeSyscallError(r, err)
+}
+
+// adoptPending takes the abandoned waiter for the range and lock
+// operation, if any. A nil waiter is returned if there is none, or if
+// it already finished (in which case it no longer holds, or waits for,
+// the range).
+func (o *unixRangeLocker) adoptPending(key rangeWait) (*os.File, *lockWaiter) {
+	o.mutex.Lock()
+	pending, ok := o.pending[key]
+	delete(o.pending, key)
+	o.mutex.Unlock()
+
+	if !ok {
+		return nil, nil
+	}
+
+	if !pending.waiter.adopt(key.how) {
+		pending.file.Close()
+		return nil, nil
+	}
+
+	return pending.file, pending.waiter
+}
+
+// abandon keeps an abandoned waiter so that the next attempt to lock
+// the range can adopt it. If a waiter for the range is already kept, or
+// if the RangeLocker was closed, the waiter is detached instead.
+func (o *unixRangeLocker) abandon(key rangeWait, file *os.File, waiter *lockWaiter) {
+	o.mutex.Lock()
+	_, exists := o.pending[key]
+	if !exists && !o.closed {
+		o.pending[key] = pendingRangeWait{
+			file:   file,
+			waiter: waiter,
+		}
+		o.mutex.Unlock()
+		return
+	}
+	o.mutex.Unlock()
+
+	if !waiter.detach() {
+		file.Close()
+	}
+}
+
+func (o *unixRangeLocker) tryLockRange(offset int64, length int64, how int) (bool, error) {
+	_, file, locked, err := o.tryLockRangeFile(offset, length, how)
+	if err != nil {
+		return false, err
+	}
+
+	if !locked {
+		file.Close()
+	}
+
+	return locked, nil
+}
+
+// tryLockRangeFile opens a new file descriptor and attempts to lock the
+// range with it without blocking. If the range was locked, it is
+// recorded as held. If the range conflicts with a locked range, the
+// still open file is returned so that the caller can wait for the range.
+func (o *unixRangeLocker) tryLockRangeFile(offset int64, length int64, how int) (byteRange, *os.File, bool, error) {
+	r, err := newByteRange(offset, length)
+	if err != nil {
+		return r, nil, false, err
+	}
+
+	o.mutex.Lock()
+	closed := o.closed
+	o.mutex.Unlock()
+	if closed {
+		return r, nil, false, newClosedError()
+	}
+
+	file, err := o.open(how)
+	if err != nil {
+		return r, nil, false, err
+	}
+
+	err = ofdLock(int(file.Fd()), how|unix.LOCK_NB, r.offset, r.length)
+	switch err {
+	case nil:
+		return r, nil, true, o.addHeld(r, file)
+	case unix.EWOULDBLOCK:
+		return r, file, false, nil
+	default:
+		file.Close()
+		return r, nil, false, newRangeSyscallError(r, err)
+	}
+}
+
+// addHeld records a locked range as held by the RangeLocker. If the
+// RangeLocker was closed in the meantime, the range is released.
+func (o *unixRangeLocker) addHeld(r byteRange, file *os.File) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		file.Close()
+		return newClosedError()
+	}
+
+	o.held[r] = append(o.held[r], file)
+
+	return nil
+}
+
+// open opens a new file descriptor for the file. Exclusive locks
+// require the file to be writable.
+func (o *unixRangeLocker) open(how int) (*os.File, error) {
+	flag := os.O_RDONLY
+	if how == unix.LOCK_EX {
+		flag = os.O_RDWR
+	}
+
+	var file *os.File
+	var err error
+	if o.config.Scope == SystemScope {
+		file, err = openSharedFile(o.config.Resource, flag)
+	} else {
+		file, err = os.OpenFile(o.config.Resource, flag|os.O_CREATE, lockMode)
+	}
+	if err != nil {
+		return nil, &LockError{
+			reason:     fmt.Sprintf("%s %s", unableToCreatePrefix, err.Error()),
+			createFail: true,
+		}
+	}
+
+	return file, nil
+}
+
+func (o *unixRangeLocker) UnlockRange(offset int64, length int64) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	r := byteRange{
+		offset: offset,
+		length: length,
+	}
+
+	files := o.held[r]
+	if len(files) == 0 {
+		panic(fmt.Sprintf("ipcm: unlock of unlocked range %s", r.String()))
+	}
+
+	// Closing the file descriptor releases its lock.
+	files[len(files)-1].Close()
+
+	if len(files) == 1 {
+		delete(o.held, r)
+	} else {
+		o.held[r] = files[:len(files)-1]
+	}
+}
+
+func (o *unixRangeLocker) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return newAlreadyClosedError()
+	}
+
+	if len(o.held) > 0 {
+		return newStillLockedError()
+	}
+
+	o.closed = true
+
+	for key, pending := range o.pending {
+		if !pending.waiter.detach() {
+			pending.file.Close()
+		}
+		delete(o.pending, key)
+	}
+
+	return nil
+}
+
+// NewRangeLocker creates a new RangeLocker for the file specified by the
+// MutexConfig's resource. The file is created if it does not exist. It
+// is never removed, regardless of the MutexConfig's RemoveOnClose.
+//
+// Ranges are locked using open file description (OFD) locks, which are
+// only available on Linux. A *ConfigureError is returned on other
+// operating systems, or if a backend that does not lock files is
+// specified.
+func NewRangeLocker(config MutexConfig) (RangeLocker, error) {
+	if !ofdSupported {
+		return nil, &ConfigureError{
+			reason: fmt.Sprintf("%s RangeLocker is not supported on this operating system",
+				configureErrPrefix),
+			notSupported: true,
+		}
+	}
+
+	if !usesLockFile(config) {
+		return nil, newUnsupportedBackendError(config.Backend)
+	}
+
+	if config.LeaseTTL > 0 {
+		return nil, newUnsupportedOptionError("leases", "RangeLocker")
+	}
+
+	if config.FencingTokens {
+		return nil, newUnsupportedOptionError("fencing tokens", "RangeLocker")
+	}
+
+	if config.Fair {
+		return nil, newUnsupportedOptionError("fair locks", "RangeLocker")
+	}
+
+	if len(config.DeadlockRegistry) > 0 {
+		return nil, newUnsupportedOptionError("deadlock detection", "RangeLocker")
+	}
+
+	config, err := validateUnixConfig(config, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixRangeLocker{
+		held:    make(map[byteRange][]*os.File),
+		pending: make(map[rangeWait]pendingRangeWait),
+		config:  config,
+	}, nil
+}
+
+func newRangeSyscallError(r byteRange, err error) *LockError {
+	return &LockError{
+		reason: fmt.Sprintf("%s failed to lock byte range %s - %s",
+			unableToAcquirePrefix, r.String(), err.Error()),
+		syscallFailed: true,
+	}
+}
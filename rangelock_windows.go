@@ -0,0 +1,17 @@
+package ipcm
+
+import (
+	"fmt"
+)
+
+// NewRangeLocker creates a new RangeLocker.
+//
+// RangeLocker is not currently supported on Windows. A *ConfigureError
+// is always returned.
+func NewRangeLocker(config MutexConfig) (RangeLocker, error) {
+	return nil, &ConfigureError{
+		reason: fmt.Sprintf("%s RangeLocker is not supported on this operating system",
+			configureErrPrefix),
+		notSupported: true,
+	}
+}
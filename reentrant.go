@@ -0,0 +1,159 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	lastOwnerToken uint64
+)
+
+// OwnerToken identifies the owner of a ReentrantMutex. Go does not
+// expose goroutine IDs, so callers that may re-enter a code path that
+// already holds a ReentrantMutex must pass the same OwnerToken along
+// the way. Create one using NewOwnerToken. The zero value is not
+// a valid OwnerToken.
+type OwnerToken uint64
+
+// NewOwnerToken returns an OwnerToken that is unique within the
+// current process.
+func NewOwnerToken() OwnerToken {
+	return OwnerToken(atomic.AddUint64(&lastOwnerToken, 1))
+}
+
+// ReentrantMutex is a Mutex that can be locked multiple times by the
+// same owner. Each lock increments the owner's hold count, and each
+// unlock decrements it. The OS mutex is only unlocked once the hold
+// count returns to zero.
+//
+// Routines (in any process) that do not own the ReentrantMutex block
+// when attempting to lock it, just like they would with a Mutex.
+type ReentrantMutex interface {
+	// Lock locks the ReentrantMutex on behalf of the owner. If the
+	// owner already holds it, the hold count is incremented instead.
+	// This call will panic if the ReentrantMutex has been closed, or if
+	// the OwnerToken is invalid.
+	Lock(owner OwnerToken)
+
+	// LockContext is like Lock, but gives up when the provided
+	// context.Context is done. If the context is done before the
+	// ReentrantMutex is locked, ctx.Err() is returned.
+	LockContext(ctx context.Context, owner OwnerToken) error
+
+	// TimedTryLock is like Lock, but gives up after the specified
+	// timeout. A non-nil error is returned when the ReentrantMutex
+	// cannot be locked in time.
+	TimedTryLock(owner OwnerToken, timeout time.Duration) error
+
+	// Unlock decrements the owner's hold count, unlocking the OS mutex
+	// once it reaches zero. This call will panic if the ReentrantMutex
+	// is not held by the owner.
+	Unlock(owner OwnerToken)
+
+	// UnlockErr is like Unlock, but returns an *UnlockError if the OS
+	// mutex could not be unlocked.
+	UnlockErr(owner OwnerToken) error
+
+	// Close releases the OS resources used by the ReentrantMutex.
+	// The ReentrantMutex must be unlocked.
+	Close() error
+}
+
+type reentrantMutex struct {
+	mutex sync.Mutex
+	inner Mutex
+	owner OwnerToken
+	count int
+}
+
+func (o *reentrantMutex) Lock(owner OwnerToken) {
+	panicOnLockError(o.LockContext(blockingContext(), owner))
+}
+
+func (o *reentrantMutex) LockContext(ctx context.Context, owner OwnerToken) error {
+	return o.lock(owner, func() error {
+		return o.inner.LockContext(ctx)
+	})
+}
+
+func (o *reentrantMutex) TimedTryLock(owner OwnerToken, timeout time.Duration) error {
+	return o.lock(owner, func() error {
+		return o.inner.TimedTryLock(timeout)
+	})
+}
+
+// lock increments the hold count if the owner already holds the
+// ReentrantMutex. Otherwise, lockInner is called to lock the underlying
+// Mutex.
+func (o *reentrantMutex) lock(owner OwnerToken, lockInner func() error) error {
+	if owner == 0 {
+		return &LockError{
+			reason: fmt.Sprintf("%s the owner token is not valid", unableToAcquirePrefix),
+		}
+	}
+
+	o.mutex.Lock()
+	if o.count > 0 && o.owner == owner {
+		o.count++
+		o.mutex.Unlock()
+		return nil
+	}
+	o.mutex.Unlock()
+
+	err := lockInner()
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	o.owner = owner
+	o.count = 1
+	o.mutex.Unlock()
+
+	return nil
+}
+
+func (o *reentrantMutex) Unlock(owner OwnerToken) {
+	o.UnlockErr(owner)
+}
+
+func (o *reentrantMutex) UnlockErr(owner OwnerToken) error {
+	o.mutex.Lock()
+
+	if o.count == 0 || o.owner != owner {
+		o.mutex.Unlock()
+		panic("ipcm: unlock of reentrant mutex by non-owner")
+	}
+
+	o.count--
+	if o.count > 0 {
+		o.mutex.Unlock()
+		return nil
+	}
+
+	o.owner = 0
+	o.mutex.Unlock()
+
+	return o.inner.UnlockErr()
+}
+
+func (o *reentrantMutex) Close() error {
+	return o.inner.Close()
+}
+
+// NewReentrantMutex creates a new ReentrantMutex. Processes can use the
+// same MutexConfig to reference the ReentrantMutex as a Mutex.
+func NewReentrantMutex(config MutexConfig) (ReentrantMutex, error) {
+	inner, err := NewMutex(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reentrantMutex{
+		inner: inner,
+	}, nil
+}
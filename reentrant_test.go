@@ -0,0 +1,99 @@
+package ipcm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNewReentrantMutex(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewReentrantMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	owner := NewOwnerToken()
+
+	m.Lock(owner)
+
+	err = m.TimedTryLock(owner, 0)
+	if err != nil {
+		t.Fatalf("the owner should be able to lock the mutex again - %s", err.Error())
+	}
+
+	err = m.LockContext(context.Background(), owner)
+	if err != nil {
+		t.Fatalf("the owner should be able to lock the mutex again - %s", err.Error())
+	}
+
+	err = m.TimedTryLock(NewOwnerToken(), 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("a different owner should not be able to lock the mutex")
+	}
+
+	for i := 0; i < 2; i++ {
+		m.Unlock(owner)
+
+		err = other.TimedTryLock(100 * time.Millisecond)
+		if err == nil {
+			t.Fatal("the OS mutex should be held until the hold count reaches zero")
+		}
+	}
+
+	err = m.UnlockErr(owner)
+	if err != nil {
+		t.Fatalf("failed to unlock mutex - %s", err.Error())
+	}
+
+	err = other.TimedTryLock(time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the hold count reached zero - %s", err.Error())
+	}
+	other.Unlock()
+}
+
+func TestNewReentrantMutex_UnlockByNonOwner(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewReentrantMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	owner := NewOwnerToken()
+	m.Lock(owner)
+	defer m.Unlock(owner)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("unlock by a non-owner should panic")
+		}
+	}()
+
+	m.Unlock(NewOwnerToken())
+}
+
+func TestNewReentrantMutex_InvalidOwner(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewReentrantMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	err = m.LockContext(context.Background(), 0)
+	if _, ok := err.(*LockError); !ok {
+		t.Fatalf("expected a *LockError for the zero owner token - got %v", err)
+	}
+}
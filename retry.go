@@ -0,0 +1,136 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how long to wait before retrying a failed OS
+// mutex lock attempt. An attempt fails when the OS mutex cannot be
+// locked because of an error, or because it is held by another process
+// and the backend cannot wait for it to be released (e.g., when polling
+// is the only option).
+//
+// The zero value retries every 100 milliseconds until the lock attempt
+// is given up by the caller.
+type RetryPolicy struct {
+	// InitialInterval is the time to wait before the first retry.
+	// The zero value is 100 milliseconds.
+	InitialInterval time.Duration
+
+	// Multiplier is the factor by which the interval grows after each
+	// retry. Values less than or equal to one result in a constant
+	// interval. For example, a Multiplier of 2 doubles the interval
+	// after every retry (i.e., exponential backoff).
+	Multiplier float64
+
+	// MaxInterval is the upper limit of the interval. Zero means the
+	// interval is not limited.
+	MaxInterval time.Duration
+
+	// Jitter randomizes each interval by up to the specified fraction
+	// of the interval, in either direction. It must be between zero
+	// and one. Jitter prevents many waiters from retrying at the same
+	// time.
+	Jitter float64
+
+	// MaxAttempts is the maximum number of failed attempts after which
+	// a lock attempt is given up. A *LockError whose RetriesExhausted
+	// method returns true is returned when this happens. The limit
+	// only applies to methods which can return an error, such as
+	// Mutex.LockContext and Mutex.TimedTryLock. Methods which cannot
+	// (such as Mutex.Lock) keep trying. Zero means attempts are not
+	// limited.
+	MaxAttempts int
+}
+
+func (o RetryPolicy) validate() error {
+	if o.InitialInterval < 0 || o.MaxInterval < 0 || o.Multiplier < 0 ||
+		o.Jitter < 0 || o.Jitter > 1 || o.MaxAttempts < 0 {
+		return &ConfigureError{
+			reason: fmt.Sprintf("%s the retry policy is invalid - %+v",
+				configureErrPrefix, o),
+		}
+	}
+
+	return nil
+}
+
+// newBackoff returns a backoff that tracks the retries of a single lock
+// attempt.
+func (o RetryPolicy) newBackoff() *backoff {
+	interval := o.InitialInterval
+	if interval == 0 {
+		interval = osMutexRetryInterval
+	}
+
+	return &backoff{
+		policy:   o,
+		interval: interval,
+	}
+}
+
+// backoff implements a RetryPolicy for a single lock attempt.
+type backoff struct {
+	policy   RetryPolicy
+	attempts int
+	interval time.Duration
+}
+
+// wait waits before the next retry. It returns ctx.Err() if the context
+// is done first, or a *LockError if no more attempts may be made.
+func (o *backoff) wait(ctx context.Context) error {
+	return o.waitAtMost(ctx, 0)
+}
+
+// waitAtMost is like wait, but waits for no longer than the specified
+// duration. A duration of zero or less means no limit.
+func (o *backoff) waitAtMost(ctx context.Context, limit time.Duration) error {
+	o.attempts++
+	if o.policy.MaxAttempts > 0 && o.attempts >= o.policy.MaxAttempts && !isBlocking(ctx) {
+		return &LockError{
+			reason: fmt.Sprintf("%s gave up after %d attempts to lock the OS mutex",
+				unableToAcquirePrefix, o.attempts),
+			retriesExhausted: true,
+		}
+	}
+
+	countRetry(ctx)
+
+	duration := o.next()
+	if limit > 0 && duration > limit {
+		duration = limit
+	}
+
+	return sleepContext(ctx, duration)
+}
+
+// next returns the current interval (with jitter applied) and advances
+// to the next one.
+func (o *backoff) next() time.Duration {
+	duration := o.interval
+	if o.policy.Jitter > 0 {
+		delta := o.policy.Jitter * float64(duration)
+		duration += time.Duration(delta * (2*rand.Float64() - 1))
+	}
+
+	if o.policy.Multiplier > 1 {
+		grown := float64(o.interval) * o.policy.Multiplier
+		if grown < math.MaxInt64 {
+			o.interval = time.Duration(grown)
+		}
+	}
+
+	if o.policy.MaxInterval > 0 && o.interval > o.policy.MaxInterval {
+		o.interval = o.policy.MaxInterval
+	}
+
+	if o.policy.MaxInterval > 0 && duration > o.policy.MaxInterval {
+		duration = o.policy.MaxInterval
+	}
+
+	return duration
+}
@@ -0,0 +1,103 @@
+package ipcm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Constant(t *testing.T) {
+	b := RetryPolicy{}.newBackoff()
+
+	for i := 0; i < 5; i++ {
+		interval := b.next()
+		if interval != osMutexRetryInterval {
+			t.Fatalf("expected interval %s - got %s", osMutexRetryInterval, interval)
+		}
+	}
+}
+
+func TestRetryPolicy_Exponential(t *testing.T) {
+	b := RetryPolicy{
+		InitialInterval: 10 * time.Millisecond,
+		Multiplier:      2,
+		MaxInterval:     50 * time.Millisecond,
+	}.newBackoff()
+
+	expected := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		50 * time.Millisecond,
+		50 * time.Millisecond,
+	}
+
+	for i, e := range expected {
+		interval := b.next()
+		if interval != e {
+			t.Fatalf("retry %d - expected interval %s - got %s", i, e, interval)
+		}
+	}
+}
+
+func TestRetryPolicy_Jitter(t *testing.T) {
+	b := RetryPolicy{
+		InitialInterval: 100 * time.Millisecond,
+		Jitter:          0.5,
+	}.newBackoff()
+
+	for i := 0; i < 100; i++ {
+		interval := b.next()
+		if interval < 50*time.Millisecond || interval > 150*time.Millisecond {
+			t.Fatalf("interval %s is outside of the jitter range", interval)
+		}
+	}
+}
+
+func TestRetryPolicy_Invalid(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.RetryPolicy.Jitter = 2
+
+	_, err := NewMutex(env.mutexConfig)
+	if _, ok := err.(*ConfigureError); !ok {
+		t.Fatalf("expected a *ConfigureError - got %v", err)
+	}
+}
+
+func TestRetryPolicy_MaxAttempts(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.RetryPolicy = RetryPolicy{
+		InitialInterval: 10 * time.Millisecond,
+		MaxAttempts:     3,
+	}
+
+	s1, err := NewSemaphore(env.mutexConfig, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s1.Close()
+
+	s2, err := NewSemaphore(env.mutexConfig, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s2.Close()
+
+	err = s1.Acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer s1.Release(1)
+
+	start := time.Now()
+
+	err = s2.Acquire(context.Background(), 1)
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.RetriesExhausted() {
+		t.Fatalf("acquire should have failed with a retries exhausted error - got %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("acquire should have given up quickly - took %s", time.Since(start))
+	}
+}
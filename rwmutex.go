@@ -0,0 +1,241 @@
+package ipcm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RWMutex is a reader/writer mutual exclusion lock that works across
+// process boundaries. The lock can be held by an arbitrary number of
+// readers or a single writer, regardless of which process they are in.
+//
+// Processes must use the same MutexConfig.Resource to reference the
+// RWMutex. A Mutex and an RWMutex should not be used for the same
+// resource.
+type RWMutex interface {
+	// Lock locks the RWMutex for writing. Like Mutex.Lock, this call
+	// blocks until the RWMutex can be locked.
+	Lock()
+
+	// LockContext locks the RWMutex for writing, or gives up when the
+	// provided context.Context is done. If the context is done before
+	// the RWMutex is locked, ctx.Err() is returned.
+	LockContext(ctx context.Context) error
+
+	// TimedTryLock attempts to lock the RWMutex for writing within the
+	// specified timeout. A non-nil error is returned when the RWMutex
+	// cannot be locked in time.
+	TimedTryLock(time.Duration) error
+
+	// Unlock unlocks the RWMutex for writing. This call will panic if
+	// the RWMutex is not locked for writing.
+	Unlock()
+
+	// RLock locks the RWMutex for reading. This call blocks until the
+	// RWMutex can be locked.
+	RLock()
+
+	// RLockContext locks the RWMutex for reading, or gives up when the
+	// provided context.Context is done. If the context is done before
+	// the RWMutex is locked, ctx.Err() is returned.
+	RLockContext(ctx context.Context) error
+
+	// TimedTryRLock attempts to lock the RWMutex for reading within
+	// the specified timeout. A non-nil error is returned when the
+	// RWMutex cannot be locked in time.
+	TimedTryRLock(time.Duration) error
+
+	// RUnlock undoes a single RLock call. This call will panic if the
+	// RWMutex is not locked for reading.
+	RUnlock()
+
+	// Downgrade atomically converts a write lock held by the caller
+	// into a read lock. Other writers cannot lock the RWMutex in
+	// between. The caller must later call RUnlock. This call will
+	// panic if the RWMutex is not locked for writing. Errors are
+	// ignored. Use DowngradeErr to find out if the read lock could not
+	// be acquired.
+	Downgrade()
+
+	// DowngradeErr downgrades the lock like Downgrade, but returns
+	// a *LockError if the OS mutex could not be locked for reading.
+	// In that case, the caller does not hold a read lock in the OS
+	// mutex (it must still call RUnlock), and the RWMutex is unusable -
+	// subsequent lock attempts fail with the same error.
+	DowngradeErr() error
+
+	// TimedTryUpgrade attempts to convert a read lock held by the
+	// caller into a write lock within the specified timeout. The read
+	// lock is not released while waiting, meaning other writers cannot
+	// lock the RWMutex in between. The upgrade succeeds once all other
+	// readers have unlocked the RWMutex.
+	//
+	// If a non-nil error is returned, the caller still holds the read
+	// lock. Two readers attempting to upgrade at the same time will
+	// block each other until one of them times out.
+	TimedTryUpgrade(time.Duration) error
+
+	// Close releases the OS resources used by the RWMutex. The RWMutex
+	// must be unlocked. Subsequent lock attempts fail with a *LockError
+	// whose MutexClosed method returns true.
+	Close() error
+}
+
+// syncRWMutex is an in-process reader/writer mutex. Like syncMutex,
+// routines waiting to lock a syncRWMutex can give up. Waiting writers
+// block new readers so that writers are not starved.
+type syncRWMutex struct {
+	mutex          sync.Mutex
+	readers        int
+	writer         bool
+	waitingWriters int
+	changed        chan struct{}
+}
+
+func newSyncRWMutex() *syncRWMutex {
+	return &syncRWMutex{
+		changed: make(chan struct{}),
+	}
+}
+
+func (o *syncRWMutex) lockContext(ctx context.Context) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.waitingWriters++
+	err := o.waitUnsafe(ctx, func() bool {
+		return !o.writer && o.readers == 0
+	})
+	o.waitingWriters--
+	if err != nil {
+		o.broadcastUnsafe()
+		return err
+	}
+
+	o.writer = true
+
+	return nil
+}
+
+// tryLock locks the syncRWMutex for writing if it is available. It
+// returns false if the syncRWMutex is already locked.
+func (o *syncRWMutex) tryLock() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.writer || o.readers > 0 {
+		return false
+	}
+
+	o.writer = true
+
+	return true
+}
+
+func (o *syncRWMutex) unlock() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !o.writer {
+		panic("ipcm: unlock of unlocked rwmutex")
+	}
+
+	o.writer = false
+	o.broadcastUnsafe()
+}
+
+// downgrade converts the write lock into a read lock.
+func (o *syncRWMutex) downgrade() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if !o.writer {
+		panic("ipcm: downgrade of rwmutex that is not locked for writing")
+	}
+
+	o.writer = false
+	o.readers = 1
+	o.broadcastUnsafe()
+}
+
+// upgradeContext converts one of the read locks into a write lock once
+// all other readers have unlocked. New readers are blocked while
+// waiting.
+func (o *syncRWMutex) upgradeContext(ctx context.Context) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.readers == 0 {
+		panic("ipcm: upgrade of rwmutex that is not locked for reading")
+	}
+
+	o.waitingWriters++
+	err := o.waitUnsafe(ctx, func() bool {
+		return o.readers == 1
+	})
+	o.waitingWriters--
+	if err != nil {
+		o.broadcastUnsafe()
+		return err
+	}
+
+	o.readers = 0
+	o.writer = true
+
+	return nil
+}
+
+func (o *syncRWMutex) rlockContext(ctx context.Context) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	err := o.waitUnsafe(ctx, func() bool {
+		return !o.writer && o.waitingWriters == 0
+	})
+	if err != nil {
+		return err
+	}
+
+	o.readers++
+
+	return nil
+}
+
+func (o *syncRWMutex) runlock() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.readers == 0 {
+		panic("ipcm: runlock of unlocked rwmutex")
+	}
+
+	o.readers--
+	if o.readers == 0 {
+		o.broadcastUnsafe()
+	}
+}
+
+// waitUnsafe waits until ready returns true or the context is done.
+// The caller must hold the mutex, which is released while waiting.
+func (o *syncRWMutex) waitUnsafe(ctx context.Context, ready func() bool) error {
+	for !ready() {
+		changed := o.changed
+		o.mutex.Unlock()
+
+		select {
+		case <-changed:
+			o.mutex.Lock()
+		case <-ctx.Done():
+			o.mutex.Lock()
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (o *syncRWMutex) broadcastUnsafe() {
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
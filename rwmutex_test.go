@@ -0,0 +1,54 @@
+package ipcm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSyncRWMutex_WaitingWriterBlocksReaders(t *testing.T) {
+	m := newSyncRWMutex()
+
+	err := m.rlockContext(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- m.lockContext(ctx)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+
+	readCtx, readCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer readCancel()
+
+	err = m.rlockContext(readCtx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("reader should be blocked by a waiting writer - got %v", err)
+	}
+
+	err = <-writerDone
+	if err != context.DeadlineExceeded {
+		t.Fatalf("writer should have timed out - got %v", err)
+	}
+
+	// The abandoned writer must not keep blocking readers.
+	err = m.rlockContext(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m.runlock()
+	m.runlock()
+
+	err = m.lockContext(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	m.unlock()
+}
@@ -0,0 +1,395 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	writerFileSuffix = ".writer"
+)
+
+// unixRWMutex implements RWMutex using two lock files. Readers hold
+// a shared lock on the resource file. Writers hold an exclusive lock on
+// the resource file, as well as an exclusive lock on the writer file.
+//
+// The writer file exists because flock(2) cannot atomically convert
+// a lock between shared and exclusive. The existing lock is released
+// first, which would allow another writer to slip in. Holding the
+// writer file while converting the resource file's lock prevents that.
+type unixRWMutex struct {
+	mutex      *syncRWMutex
+	osMutex    syncMutex
+	readers    int
+	file       *lockFile
+	writerFile *lockFile
+	closed     bool
+	broken     error
+	config     MutexConfig
+}
+
+func (o *unixRWMutex) Lock() {
+	panicOnLockError(o.LockContext(blockingContext()))
+}
+
+func (o *unixRWMutex) LockContext(ctx context.Context) error {
+	err := o.lockSyncMutex(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = o.lockOsMutexUnsafe(ctx)
+	if err != nil {
+		o.mutex.unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (o *unixRWMutex) TimedTryLock(timeout time.Duration) error {
+	return timedTryLock(context.Background(), timeout, o.lockSyncMutex, o.lockOsMutexUnsafe, o.mutex.unlock)
+}
+
+// lockSyncMutex locks the in-process mutex for writing. It fails if the
+// RWMutex has been closed.
+func (o *unixRWMutex) lockSyncMutex(ctx context.Context) error {
+	err := o.mutex.lockContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if o.closed {
+		o.mutex.unlock()
+		return newClosedError()
+	}
+
+	if o.broken != nil {
+		o.mutex.unlock()
+		return o.broken
+	}
+
+	return nil
+}
+
+// lockOsMutexUnsafe locks the writer file and the resource file
+// exclusively. The caller must hold the in-process mutex for writing,
+// which guarantees that no readers in this process hold the shared lock.
+func (o *unixRWMutex) lockOsMutexUnsafe(ctx context.Context) error {
+	err := o.osMutex.lockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.osMutex.unlock()
+
+	err = o.writerFile.lock(ctx, unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	err = o.file.lock(ctx, unix.LOCK_EX)
+	if err != nil {
+		o.writerFile.unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (o *unixRWMutex) Unlock() {
+	defer o.mutex.unlock()
+
+	o.osMutex.lockContext(context.Background())
+	defer o.osMutex.unlock()
+
+	o.file.unlock()
+	o.writerFile.unlock()
+}
+
+func (o *unixRWMutex) RLock() {
+	panicOnLockError(o.RLockContext(blockingContext()))
+}
+
+func (o *unixRWMutex) RLockContext(ctx context.Context) error {
+	err := o.rlockSyncMutex(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = o.rlockOsMutexUnsafe(ctx)
+	if err != nil {
+		o.mutex.runlock()
+		return err
+	}
+
+	return nil
+}
+
+func (o *unixRWMutex) TimedTryRLock(timeout time.Duration) error {
+	return timedTryLock(context.Background(), timeout, o.rlockSyncMutex, o.rlockOsMutexUnsafe, o.mutex.runlock)
+}
+
+// rlockSyncMutex locks the in-process mutex for reading. It fails if
+// the RWMutex has been closed.
+func (o *unixRWMutex) rlockSyncMutex(ctx context.Context) error {
+	err := o.mutex.rlockContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if o.closed {
+		o.mutex.runlock()
+		return newClosedError()
+	}
+
+	if o.broken != nil {
+		o.mutex.runlock()
+		return o.broken
+	}
+
+	return nil
+}
+
+// rlockOsMutexUnsafe locks the resource file in shared mode on behalf
+// of a reader. All readers in this process share a single OS lock, which
+// is acquired by the first reader and released by the last one.
+func (o *unixRWMutex) rlockOsMutexUnsafe(ctx context.Context) error {
+	err := o.osMutex.lockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.osMutex.unlock()
+
+	if o.readers == 0 {
+		err := o.file.lock(ctx, unix.LOCK_SH)
+		if err != nil {
+			return err
+		}
+	}
+
+	o.readers++
+
+	return nil
+}
+
+func (o *unixRWMutex) RUnlock() {
+	o.osMutex.lockContext(context.Background())
+
+	if o.readers > 0 {
+		o.readers--
+		if o.readers == 0 {
+			o.file.unlock()
+		}
+	}
+
+	o.osMutex.unlock()
+
+	o.mutex.runlock()
+}
+
+func (o *unixRWMutex) Downgrade() {
+	o.DowngradeErr()
+}
+
+func (o *unixRWMutex) DowngradeErr() error {
+	defer o.mutex.downgrade()
+
+	o.osMutex.lockContext(context.Background())
+	defer o.osMutex.unlock()
+
+	// Converting the exclusive lock to a shared lock cannot conflict
+	// with other processes because they need the writer file in order
+	// to hold the resource file exclusively. Only readers can lock the
+	// resource file while it is being converted.
+	err := o.restoreSharedLockUnsafe()
+	if err == nil {
+		o.readers = 1
+	}
+	o.writerFile.unlock()
+
+	return err
+}
+
+// restoreSharedLockUnsafe locks the resource file in shared mode after
+// the caller held it exclusively, or after a failed attempt to convert
+// a shared lock into an exclusive one (flock(2) does not convert locks
+// atomically, so a failed conversion may release the shared lock).
+// The caller must hold the writer file, so the shared lock can be
+// acquired without blocking.
+//
+// If the shared lock cannot be restored, the RWMutex is marked as
+// unusable, because the caller believes that it holds a read lock
+// which it no longer does. Subsequent lock attempts fail with the
+// returned *LockError.
+func (o *unixRWMutex) restoreSharedLockUnsafe() error {
+	locked, err := o.file.tryLock(unix.LOCK_SH)
+	if err == nil && !locked {
+		err = unix.EWOULDBLOCK
+	}
+	if err == nil {
+		return nil
+	}
+
+	// Make sure that no lock is left behind.
+	o.file.unlock()
+
+	o.readers = 0
+	o.broken = &LockError{
+		reason: fmt.Sprintf("%s the rwmutex is unusable because its shared lock could not be restored - %s",
+			unableToAcquirePrefix, err.Error()),
+		syscallFailed: true,
+	}
+
+	return o.broken
+}
+
+func (o *unixRWMutex) TimedTryUpgrade(timeout time.Duration) error {
+	return timedTryLock(context.Background(), timeout, o.mutex.upgradeContext, o.upgradeOsMutexUnsafe, o.mutex.downgrade)
+}
+
+// upgradeOsMutexUnsafe converts the shared lock on the resource file
+// into an exclusive lock. The caller must have upgraded the in-process
+// mutex, which guarantees that it is the only reader in this process.
+//
+// The writer file is locked first so that no other writer can lock the
+// resource file in the meantime. Converting the lock is attempted
+// without blocking because a blocking flock(2) call would release the
+// shared lock for as long as it waits.
+func (o *unixRWMutex) upgradeOsMutexUnsafe(ctx context.Context) error {
+	err := o.osMutex.lockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer o.osMutex.unlock()
+
+	err = o.writerFile.lock(ctx, unix.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	backoff := o.config.RetryPolicy.newBackoff()
+
+	for {
+		locked, lockErr := o.file.tryLock(unix.LOCK_EX)
+		if locked {
+			o.readers = 0
+			return nil
+		}
+
+		// The failed conversion may have released the shared lock.
+		// Restoring it cannot block because other processes need
+		// the writer file in order to lock the resource file
+		// exclusively.
+		err := o.restoreSharedLockUnsafe()
+		if err != nil {
+			o.writerFile.unlock()
+			return err
+		}
+
+		if lockErr != nil {
+			o.writerFile.unlock()
+			return &LockError{
+				reason: fmt.Sprintf("%s failed to convert the shared lock - %s",
+					unableToAcquirePrefix, lockErr.Error()),
+				syscallFailed: true,
+			}
+		}
+
+		err = backoff.wait(ctx)
+		if err != nil {
+			o.writerFile.unlock()
+			return err
+		}
+	}
+}
+
+func (o *unixRWMutex) Close() error {
+	if !o.mutex.tryLock() {
+		return newStillLockedError()
+	}
+	defer o.mutex.unlock()
+
+	if o.closed {
+		return newAlreadyClosedError()
+	}
+
+	o.closed = true
+
+	err := o.file.close(o.config.RemoveOnClose)
+	writerErr := o.writerFile.close(o.config.RemoveOnClose)
+	if err == nil {
+		err = writerErr
+	}
+	if err != nil {
+		return &CloseError{
+			reason:        fmt.Sprintf("%s %s", closeErrPrefix, err.Error()),
+			syscallFailed: true,
+		}
+	}
+
+	return nil
+}
+
+// NewRWMutex creates a new RWMutex. On unix systems, readers hold
+// a shared flock(2) lock on the resource file and writers hold an
+// exclusive one. Writers also lock a second file whose path is the
+// resource's path suffixed with '.writer'.
+func NewRWMutex(config MutexConfig) (RWMutex, error) {
+	config, err := validateUnixConfig(config, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Backend != DefaultBackend {
+		return nil, newUnsupportedBackendError(config.Backend)
+	}
+
+	if config.LeaseTTL > 0 {
+		return nil, newUnsupportedOptionError("leases", "RWMutex")
+	}
+
+	if config.FencingTokens {
+		return nil, newUnsupportedOptionError("fencing tokens", "RWMutex")
+	}
+
+	if config.Fair {
+		return nil, newUnsupportedOptionError("fair locks", "RWMutex")
+	}
+
+	if len(config.DeadlockRegistry) > 0 {
+		return nil, newUnsupportedOptionError("deadlock detection", "RWMutex")
+	}
+
+	mu := &unixRWMutex{
+		mutex:   newSyncRWMutex(),
+		osMutex: newSyncMutex(),
+		file: &lockFile{
+			path:   config.Resource,
+			retry:  config.RetryPolicy,
+			shared: config.Scope == SystemScope,
+		},
+		writerFile: &lockFile{
+			path:   config.Resource + writerFileSuffix,
+			retry:  config.RetryPolicy,
+			shared: config.Scope == SystemScope,
+		},
+		config: config,
+	}
+
+	err = mu.file.resetUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = mu.writerFile.resetUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	return mu, nil
+}
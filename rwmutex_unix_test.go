@@ -0,0 +1,272 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewRWMutex(t *testing.T) {
+	env := setupTestEnv(t)
+	testHarness := newProcessRLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = m.TimedTryRLock(time.Second)
+	if err != nil {
+		t.Fatalf("read lock should have succeeded while another process holds a read lock - %s",
+			err.Error())
+	}
+
+	err = m.TimedTryRLock(time.Second)
+	if err != nil {
+		t.Fatalf("second read lock should have succeeded - %s", err.Error())
+	}
+
+	m.RUnlock()
+	m.RUnlock()
+
+	err = m.TimedTryLock(time.Second)
+	if err == nil {
+		t.Fatal("write lock should have failed while another process holds a read lock")
+	}
+
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.SystemMutexLockTimedOut() {
+		t.Fatalf("expected a system mutex timeout - got %v", err)
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	err = m.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("write lock should have succeeded - %s", err.Error())
+	}
+	m.Unlock()
+}
+
+func TestNewRWMutex_WriterExcludesReaders(t *testing.T) {
+	env := setupTestEnv(t)
+
+	writer, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reader, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	writer.Lock()
+
+	err = writer.TimedTryRLock(100 * time.Millisecond)
+	if err == nil {
+		t.Fatal("read lock should have failed while the same RWMutex is locked for writing")
+	}
+
+	err = reader.TimedTryRLock(100 * time.Millisecond)
+	if err == nil {
+		t.Fatal("read lock should have failed while another RWMutex is locked for writing")
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- reader.TimedTryRLock(5 * time.Second)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	writer.Unlock()
+
+	err = <-acquired
+	if err != nil {
+		t.Fatalf("read lock should have succeeded once the writer unlocked - %s", err.Error())
+	}
+
+	err = writer.TimedTryRLock(time.Second)
+	if err != nil {
+		t.Fatalf("read locks should be shared - %s", err.Error())
+	}
+
+	writer.RUnlock()
+	reader.RUnlock()
+}
+
+func TestNewRWMutex_Downgrade(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reader, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	writer, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m.Lock()
+
+	writerDone := make(chan error, 1)
+	go func() {
+		writerDone <- writer.TimedTryLock(5 * time.Second)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	m.Downgrade()
+
+	err = reader.TimedTryRLock(time.Second)
+	if err != nil {
+		t.Fatalf("read lock should have succeeded after downgrade - %s", err.Error())
+	}
+
+	select {
+	case err := <-writerDone:
+		t.Fatalf("writer should not have locked the mutex after downgrade - got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	reader.RUnlock()
+	m.RUnlock()
+
+	err = <-writerDone
+	if err != nil {
+		t.Fatalf("writer should have locked the mutex once readers unlocked - %s", err.Error())
+	}
+	writer.Unlock()
+}
+
+func TestNewRWMutex_TimedTryUpgrade(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	reader, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	writer, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m.RLock()
+	reader.RLock()
+
+	err = m.TimedTryUpgrade(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("upgrade should have failed while another process holds a read lock")
+	}
+
+	reader.RUnlock()
+
+	// The failed upgrade must not have released the read lock.
+	err = writer.TimedTryLock(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("write lock should have failed while the read lock is still held")
+	}
+
+	err = m.TimedTryUpgrade(time.Second)
+	if err != nil {
+		t.Fatalf("upgrade should have succeeded - %s", err.Error())
+	}
+
+	err = reader.TimedTryRLock(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("read lock should have failed after upgrade")
+	}
+
+	m.Unlock()
+
+	err = writer.TimedTryLock(time.Second)
+	if err != nil {
+		t.Fatalf("write lock should have succeeded - %s", err.Error())
+	}
+	writer.Unlock()
+}
+
+func TestNewRWMutex_DowngradeErr(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.Lock()
+
+	// Closing the file descriptor makes the shared lock fail.
+	m.(*unixRWMutex).file.file.Close()
+
+	err = m.DowngradeErr()
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.SystemCallFailed() {
+		t.Fatalf("expected a system call error - got %v", err)
+	}
+
+	m.RUnlock()
+
+	err = m.TimedTryRLock(100 * time.Millisecond)
+	if err != lockErr {
+		t.Fatalf("rwmutex should be unusable - got %v", err)
+	}
+
+	err = m.TimedTryLock(100 * time.Millisecond)
+	if err != lockErr {
+		t.Fatalf("rwmutex should be unusable - got %v", err)
+	}
+}
+
+func TestNewRWMutex_TimedTryUpgradeErr(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewRWMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	m.RLock()
+
+	// Closing the file descriptor makes converting the lock fail
+	// with an error that must not be retried.
+	m.(*unixRWMutex).file.file.Close()
+
+	start := time.Now()
+	err = m.TimedTryUpgrade(5 * time.Second)
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.SystemCallFailed() {
+		t.Fatalf("expected a system call error - got %v", err)
+	}
+
+	if time.Since(start) >= time.Second {
+		t.Fatal("upgrade should have failed right away")
+	}
+
+	m.RUnlock()
+
+	err = m.TimedTryRLock(100 * time.Millisecond)
+	if err != lockErr {
+		t.Fatalf("rwmutex should be unusable - got %v", err)
+	}
+}
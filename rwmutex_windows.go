@@ -0,0 +1,17 @@
+package ipcm
+
+import (
+	"fmt"
+)
+
+// NewRWMutex creates a new RWMutex.
+//
+// RWMutex is not currently supported on Windows. A *ConfigureError is
+// always returned.
+func NewRWMutex(config MutexConfig) (RWMutex, error) {
+	return nil, &ConfigureError{
+		reason: fmt.Sprintf("%s RWMutex is not supported on this operating system",
+			configureErrPrefix),
+		notSupported: true,
+	}
+}
@@ -0,0 +1,264 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+const (
+	slotFree slotState = iota
+	slotClaimed
+	slotHeld
+)
+
+// Semaphore is a counting semaphore that works across process
+// boundaries. It limits how many routines (in any process) can hold one
+// of its permits at the same time.
+//
+// Each permit is backed by a Mutex, meaning permits held by a process
+// are returned automatically if the process exits without releasing
+// them.
+type Semaphore interface {
+	// Acquire acquires n permits, blocking until they are available or
+	// until the provided context.Context is done. If the context is done
+	// first, ctx.Err() is returned and no permits are acquired.
+	//
+	// Permits are acquired all at once. A caller waiting for several
+	// permits does not hold on to some of them while waiting for the
+	// rest.
+	Acquire(ctx context.Context, n int) error
+
+	// TryAcquire acquires n permits without blocking. It returns true
+	// if the permits were acquired.
+	TryAcquire(n int) bool
+
+	// Release releases n permits previously acquired by this
+	// Semaphore. This call will panic if fewer than n permits are held.
+	Release(n int)
+
+	// Close releases the OS resources used by the Semaphore. All
+	// permits must be released first. Subsequent attempts to acquire
+	// permits fail.
+	Close() error
+}
+
+// slotState is the state of one of a semaphore's permits within the
+// current process.
+type slotState int
+
+type semaphore struct {
+	mutex  sync.Mutex
+	slots  []Mutex
+	states []slotState
+	closed bool
+	retry  RetryPolicy
+}
+
+func (o *semaphore) Acquire(ctx context.Context, n int) error {
+	err := o.validateCount(n)
+	if err != nil {
+		return err
+	}
+
+	backoff := o.retry.newBackoff()
+
+	for {
+		if o.TryAcquire(n) {
+			return nil
+		}
+
+		if o.isClosed() {
+			return newClosedError()
+		}
+
+		err := backoff.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (o *semaphore) TryAcquire(n int) bool {
+	if o.validateCount(n) != nil {
+		return false
+	}
+
+	var acquired []int
+
+	for i := range o.slots {
+		if len(acquired) == n {
+			break
+		}
+
+		if !o.claimSlot(i) {
+			continue
+		}
+
+		err := o.slots[i].TimedTryLock(0)
+		if err != nil {
+			o.unclaimSlot(i)
+			continue
+		}
+
+		o.setSlotState(i, slotHeld)
+		acquired = append(acquired, i)
+	}
+
+	if len(acquired) == n {
+		return true
+	}
+
+	for _, i := range acquired {
+		o.releaseSlot(i)
+	}
+
+	return false
+}
+
+func (o *semaphore) Release(n int) {
+	o.mutex.Lock()
+	var held []int
+	for i := range o.states {
+		if len(held) == n {
+			break
+		}
+		if o.states[i] == slotHeld {
+			held = append(held, i)
+			o.states[i] = slotClaimed
+		}
+	}
+	o.mutex.Unlock()
+
+	if len(held) < n {
+		for _, i := range held {
+			o.setSlotState(i, slotHeld)
+		}
+		panic("ipcm: semaphore released more permits than held")
+	}
+
+	for _, i := range held {
+		o.releaseSlot(i)
+	}
+}
+
+func (o *semaphore) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return newAlreadyClosedError()
+	}
+
+	for _, state := range o.states {
+		if state != slotFree {
+			return newStillLockedError()
+		}
+	}
+
+	o.closed = true
+
+	var firstErr error
+	for _, slot := range o.slots {
+		err := slot.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+func (o *semaphore) isClosed() bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.closed
+}
+
+// claimSlot marks a free slot as claimed by a routine in this process so
+// that other routines do not attempt to lock it. It returns false if the
+// slot is not free.
+func (o *semaphore) claimSlot(i int) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed || o.states[i] != slotFree {
+		return false
+	}
+
+	o.states[i] = slotClaimed
+
+	return true
+}
+
+func (o *semaphore) unclaimSlot(i int) {
+	o.setSlotState(i, slotFree)
+}
+
+func (o *semaphore) setSlotState(i int, state slotState) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.states[i] = state
+}
+
+func (o *semaphore) releaseSlot(i int) {
+	o.slots[i].Unlock()
+	o.unclaimSlot(i)
+}
+
+func (o *semaphore) validateCount(n int) error {
+	if n < 1 || n > len(o.slots) {
+		return &LockError{
+			reason: fmt.Sprintf("%s requested %d permits from a semaphore with %d permits",
+				unableToAcquirePrefix, n, len(o.slots)),
+		}
+	}
+
+	return nil
+}
+
+// NewSemaphore creates a new Semaphore with the specified number of
+// permits. Processes must use the same MutexConfig and number of permits
+// to reference the Semaphore.
+//
+// Each permit is backed by a Mutex whose resource is the configured
+// resource suffixed with '.' and the permit's index (e.g., on unix
+// systems, '/var/myapplication/lock.0', '/var/myapplication/lock.1',
+// and so on).
+func NewSemaphore(config MutexConfig, permits int) (Semaphore, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if permits < 1 {
+		return nil, &ConfigureError{
+			reason: fmt.Sprintf("%s semaphore must have at least one permit - got %d",
+				configureErrPrefix, permits),
+		}
+	}
+
+	s := &semaphore{
+		slots:  make([]Mutex, permits),
+		states: make([]slotState, permits),
+		retry:  config.RetryPolicy,
+	}
+
+	for i := range s.slots {
+		slotConfig := config
+		slotConfig.Resource = config.Resource + "." + strconv.Itoa(i)
+
+		s.slots[i], err = NewMutex(slotConfig)
+		if err != nil {
+			for _, slot := range s.slots[:i] {
+				slot.Close()
+			}
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
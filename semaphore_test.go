@@ -0,0 +1,126 @@
+package ipcm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewSemaphore(t *testing.T) {
+	env := setupTestEnv(t)
+
+	s1, err := NewSemaphore(env.mutexConfig, 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	s2, err := NewSemaphore(env.mutexConfig, 3)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !s1.TryAcquire(2) {
+		t.Fatal("failed to acquire 2 of 3 permits")
+	}
+
+	if s2.TryAcquire(2) {
+		t.Fatal("acquired 2 permits when only 1 should be available")
+	}
+
+	if !s2.TryAcquire(1) {
+		t.Fatal("failed to acquire the last permit")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	err = s2.Acquire(ctx, 1)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("acquire should have failed with %v - got %v", context.DeadlineExceeded, err)
+	}
+
+	s1.Release(1)
+
+	err = s2.Acquire(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("acquire should have succeeded after a permit was released - %s", err.Error())
+	}
+
+	s1.Release(1)
+	s2.Release(2)
+
+	if !s2.TryAcquire(3) {
+		t.Fatal("failed to acquire all permits after they were released")
+	}
+	s2.Release(3)
+}
+
+func TestNewSemaphore_MultipleRoutines(t *testing.T) {
+	env := setupTestEnv(t)
+
+	const permits = 2
+
+	s, err := NewSemaphore(env.mutexConfig, permits)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	mutex := &sync.Mutex{}
+	current := 0
+	max := 0
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := s.Acquire(context.Background(), 1)
+			if err != nil {
+				t.Errorf("failed to acquire permit - %s", err.Error())
+				return
+			}
+			defer s.Release(1)
+
+			mutex.Lock()
+			current++
+			if current > max {
+				max = current
+			}
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			current--
+			mutex.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if max > permits {
+		t.Fatalf("%d routines held permits at the same time - expected at most %d",
+			max, permits)
+	}
+}
+
+func TestNewSemaphore_InvalidCount(t *testing.T) {
+	env := setupTestEnv(t)
+
+	_, err := NewSemaphore(env.mutexConfig, 0)
+	if err == nil {
+		t.Fatal("creating a semaphore with no permits should have failed")
+	}
+
+	s, err := NewSemaphore(env.mutexConfig, 1)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = s.Acquire(context.Background(), 2)
+	if err == nil {
+		t.Fatal("acquiring more permits than the semaphore has should have failed")
+	}
+}
@@ -0,0 +1,111 @@
+package ipcm
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	abstractSocketPrefix = "@ipcm/"
+
+	// maxAbstractSocketName is the maximum length of an abstract
+	// socket's name, including the leading '@' (which is replaced
+	// by a null byte). This is limited by the size of sun_path.
+	maxAbstractSocketName = 107
+)
+
+// abstractSocketLocker is an osLocker that binds a Unix domain socket in
+// the Linux abstract namespace. Only one socket can be bound to a given
+// name at a time. The kernel unbinds the socket when it is closed,
+// including when the process exits.
+//
+// The kernel does not notify processes when a name becomes available,
+// so lock attempts are retried according to the RetryPolicy. Other
+// errors (e.g., running out of file descriptors) are only retried by
+// lock methods that cannot return an error.
+type abstractSocketLocker struct {
+	name  string
+	fd    int
+	retry RetryPolicy
+}
+
+func newAbstractSocketLocker(resource string, retry RetryPolicy) (*abstractSocketLocker, error) {
+	name := abstractSocketPrefix + resource
+	if len(name) > maxAbstractSocketName {
+		return nil, &ConfigureError{
+			reason: fmt.Sprintf("%s the resource name is too long for an abstract socket (%d > %d bytes) - '%s'",
+				configureErrPrefix, len(name), maxAbstractSocketName, resource),
+		}
+	}
+
+	return &abstractSocketLocker{
+		name:  name,
+		fd:    -1,
+		retry: retry,
+	}, nil
+}
+
+func (o *abstractSocketLocker) lock(ctx context.Context) error {
+	backoff := o.retry.newBackoff()
+
+	for {
+		bound, err := o.tryBind()
+		if bound {
+			return nil
+		}
+
+		if err != nil && !isBlocking(ctx) {
+			return &LockError{
+				reason: fmt.Sprintf("%s failed to bind abstract socket - %s",
+					unableToAcquirePrefix, err.Error()),
+				syscallFailed: true,
+			}
+		}
+
+		err = backoff.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tryBind attempts to bind the socket. It returns false if another
+// socket is bound to the name.
+func (o *abstractSocketLocker) tryBind() (bool, error) {
+	fd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return false, err
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrUnix{
+		Name: o.name,
+	})
+	if err != nil {
+		unix.Close(fd)
+		if err == unix.EADDRINUSE {
+			return false, nil
+		}
+		return false, err
+	}
+
+	o.fd = fd
+
+	return true, nil
+}
+
+func (o *abstractSocketLocker) unlock() error {
+	if o.fd < 0 {
+		return nil
+	}
+
+	err := unix.Close(o.fd)
+	o.fd = -1
+
+	return err
+}
+
+func (o *abstractSocketLocker) close(remove bool) error {
+	return o.unlock()
+}
@@ -0,0 +1,169 @@
+package ipcm
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNewMutex_AbstractSocketBackend(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig = MutexConfig{
+		Resource: "ipcm-test/" + randStringBytesRmndr(10),
+		Backend:  AbstractSocketBackend,
+	}
+
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	err = m.TimedTryLock(500 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while another process holds the mutex")
+	}
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	err = m.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the other process exited - %s", err.Error())
+	}
+
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	err = other.TimedTryLock(200 * time.Millisecond)
+	if err == nil {
+		t.Fatal("lock attempt should have failed while another mutex in this process holds it")
+	}
+
+	m.Unlock()
+
+	err = other.TimedTryLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("lock should have succeeded once the mutex was unlocked - %s", err.Error())
+	}
+	other.Unlock()
+}
+
+func TestNewMutex_AbstractSocketBackendNameTooLong(t *testing.T) {
+	name := make([]byte, maxAbstractSocketName)
+	for i := range name {
+		name[i] = 'a'
+	}
+
+	_, err := NewMutex(MutexConfig{
+		Resource: string(name),
+		Backend:  AbstractSocketBackend,
+	})
+	if err == nil {
+		t.Fatal("creating a mutex with a name that is too long should have failed")
+	}
+}
+
+func TestNewMutex_LockIgnoresMaxAttempts(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig = MutexConfig{
+		Resource: "ipcm-test/" + randStringBytesRmndr(10),
+		Backend:  AbstractSocketBackend,
+		RetryPolicy: RetryPolicy{
+			InitialInterval: 10 * time.Millisecond,
+			MaxAttempts:     2,
+		},
+	}
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	other, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer other.Close()
+
+	m.Lock()
+
+	err = other.TimedTryLock(time.Second)
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.RetriesExhausted() {
+		t.Fatalf("lock attempt should have failed with a retries exhausted error - got %v", err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		other.Lock()
+		close(locked)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	m.Unlock()
+
+	select {
+	case <-locked:
+		other.Unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock should have succeeded once the mutex was unlocked")
+	}
+}
+
+func TestNewMutex_AbstractSocketBackendBindFails(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig = MutexConfig{
+		Resource: "ipcm-test/" + randStringBytesRmndr(10),
+		Backend:  AbstractSocketBackend,
+	}
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	var original unix.Rlimit
+	err = unix.Getrlimit(unix.RLIMIT_NOFILE, &original)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Prevent the creation of the socket.
+	limited := original
+	limited.Cur = 0
+	err = unix.Setrlimit(unix.RLIMIT_NOFILE, &limited)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	start := time.Now()
+	err = m.TimedTryLock(5 * time.Second)
+	elapsed := time.Since(start)
+
+	restoreErr := unix.Setrlimit(unix.RLIMIT_NOFILE, &original)
+	if restoreErr != nil {
+		t.Fatal(restoreErr.Error())
+	}
+
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.SystemCallFailed() {
+		t.Fatalf("expected a system call failed error - got %v", err)
+	}
+
+	if elapsed > time.Second {
+		t.Fatalf("lock attempt should have failed right away - took %s", elapsed)
+	}
+}
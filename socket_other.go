@@ -0,0 +1,26 @@
+// +build !windows,!linux
+
+package ipcm
+
+import (
+	"context"
+)
+
+// abstractSocketLocker is not supported on this operating system.
+type abstractSocketLocker struct{}
+
+func newAbstractSocketLocker(resource string, retry RetryPolicy) (*abstractSocketLocker, error) {
+	return nil, newUnsupportedBackendError(AbstractSocketBackend)
+}
+
+func (o *abstractSocketLocker) lock(ctx context.Context) error {
+	return ctx.Err()
+}
+
+func (o *abstractSocketLocker) unlock() error {
+	return nil
+}
+
+func (o *abstractSocketLocker) close(remove bool) error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package ipcm
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	liveMutexesMutex sync.Mutex
+	liveMutexes      = make(map[*mutexStatus]struct{})
+)
+
+// MutexState describes a Mutex in the current process. Refer to
+// LiveMutexes for more information.
+type MutexState struct {
+	// Resource is the Mutex's MutexConfig.Resource.
+	Resource string `json:"resource"`
+
+	// Backend is the name of the Mutex's MutexConfig.Backend.
+	Backend string `json:"backend"`
+
+	// Label is the Mutex's MutexConfig.Label.
+	Label string `json:"label,omitempty"`
+
+	// Held is true if a routine in the current process holds the Mutex.
+	Held bool `json:"held"`
+
+	// HeldSince is when the Mutex was locked. It is nil if the Mutex is
+	// not held.
+	HeldSince *time.Time `json:"held_since,omitempty"`
+
+	// HeldForSeconds is how long the Mutex has been held for, in
+	// seconds.
+	HeldForSeconds float64 `json:"held_for_seconds,omitempty"`
+
+	// Waiters is the number of routines waiting for the in-process
+	// mutex, i.e., for another routine in the current process to
+	// unlock the Mutex (or to give up on locking it).
+	Waiters int `json:"waiters"`
+}
+
+// LiveMutexes returns the state of every Mutex in the current process
+// that was created by NewMutex and has not been closed (or garbage
+// collected) yet, sorted by resource. It is intended for debugging,
+// e.g., to find out which Mutex a hung process is waiting for. The
+// ipcm/inspect package serves the states over HTTP and expvar.
+func LiveMutexes() []MutexState {
+	liveMutexesMutex.Lock()
+	statuses := make([]*mutexStatus, 0, len(liveMutexes))
+	for status := range liveMutexes {
+		statuses = append(statuses, status)
+	}
+	liveMutexesMutex.Unlock()
+
+	now := time.Now()
+
+	states := make([]MutexState, 0, len(statuses))
+	for _, status := range statuses {
+		states = append(states, status.state(now))
+	}
+
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Resource < states[j].Resource
+	})
+
+	return states
+}
+
+// mutexStatus tracks the state of a Mutex for LiveMutexes. It is safe
+// for concurrent use, so that it can be read while the Mutex is locked.
+type mutexStatus struct {
+	mutex     sync.Mutex
+	config    MutexConfig
+	held      bool
+	heldSince time.Time
+	waiters   int
+}
+
+// registerMutex adds a Mutex with the specified MutexConfig to the
+// live Mutexes.
+func registerMutex(config MutexConfig) *mutexStatus {
+	status := &mutexStatus{
+		config: config,
+	}
+
+	liveMutexesMutex.Lock()
+	liveMutexes[status] = struct{}{}
+	liveMutexesMutex.Unlock()
+
+	return status
+}
+
+// unregister removes the Mutex from the live Mutexes.
+func (o *mutexStatus) unregister() {
+	liveMutexesMutex.Lock()
+	delete(liveMutexes, o)
+	liveMutexesMutex.Unlock()
+}
+
+// waiting adds delta to the number of routines waiting for the
+// in-process mutex.
+func (o *mutexStatus) waiting(delta int) {
+	o.mutex.Lock()
+	o.waiters += delta
+	o.mutex.Unlock()
+}
+
+// acquired records that the Mutex was locked at the specified time.
+func (o *mutexStatus) acquired(at time.Time) {
+	o.mutex.Lock()
+	o.held = true
+	o.heldSince = at
+	o.mutex.Unlock()
+}
+
+// released records that the Mutex was unlocked. It returns the time at
+// which the Mutex was locked.
+func (o *mutexStatus) released() time.Time {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.held = false
+
+	return o.heldSince
+}
+
+func (o *mutexStatus) state(now time.Time) MutexState {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	state := MutexState{
+		Resource: o.config.Resource,
+		Backend:  o.config.Backend.String(),
+		Label:    o.config.Label,
+		Held:     o.held,
+		Waiters:  o.waiters,
+	}
+
+	if o.held {
+		heldSince := o.heldSince
+		state.HeldSince = &heldSince
+		state.HeldForSeconds = now.Sub(heldSince).Seconds()
+	}
+
+	return state
+}
@@ -0,0 +1,91 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	tokenFileSuffix = ".token"
+)
+
+// CurrentToken returns the fencing token of the most recent acquisition
+// of the Mutex referenced by the MutexConfig, regardless of whether the
+// Mutex is still held. Zero is returned if the Mutex was never acquired
+// with fencing tokens enabled. Refer to MutexConfig.FencingTokens for
+// more information.
+//
+// On unix systems, the token is stored in a file whose path is the
+// resource's path suffixed with '.token'.
+func CurrentToken(config MutexConfig) (uint64, error) {
+	config, err := validateUnixConfig(config, false)
+	if err != nil {
+		return 0, err
+	}
+
+	if !usesLockFile(config) {
+		return 0, &QueryError{
+			reason: fmt.Sprintf("%s fencing tokens are not recorded by the %s backend",
+				queryErrPrefix, config.Backend.String()),
+			notSupported: true,
+		}
+	}
+
+	token, err := readToken(config.Resource)
+	if err != nil {
+		return 0, &QueryError{
+			reason: fmt.Sprintf("%s failed to read fencing token - %s",
+				queryErrPrefix, err.Error()),
+		}
+	}
+
+	return token, nil
+}
+
+// readToken reads the last fencing token of the specified resource.
+func readToken(resource string) (uint64, error) {
+	raw, err := ioutil.ReadFile(resource + tokenFileSuffix)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 64)
+}
+
+// incrementToken records and returns the next fencing token of the
+// specified resource. It must only be called while the resource's Mutex
+// is held. The token is flushed to the storage device before it is
+// returned, so that tokens never go backwards after a crash.
+//
+// A *LockError is returned if the token file does not contain a valid
+// token. Unlike other errors, retrying does not fix this.
+func incrementToken(resource string) (uint64, error) {
+	token, err := readToken(resource)
+	if numErr, ok := err.(*strconv.NumError); ok {
+		return 0, &LockError{
+			reason: fmt.Sprintf("%s the fencing token file is corrupt - %s",
+				unableToAcquirePrefix, numErr.Error()),
+			tokenCorrupt: true,
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	token++
+
+	err = replaceFileDurably(resource+tokenFileSuffix, []byte(strconv.FormatUint(token, 10)))
+	if err != nil {
+		return 0, err
+	}
+
+	return token, nil
+}
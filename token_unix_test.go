@@ -0,0 +1,188 @@
+// +build !windows
+
+package ipcm
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMutex_LockToken(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.FencingTokens = true
+
+	testHarness := newProcessLocksAndIdles(env, t)
+	defer func() {
+		testHarness.Process.Kill()
+		testHarness.Wait()
+	}()
+
+	harnessToken, err := CurrentToken(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if harnessToken == 0 {
+		t.Fatal("the test harness should have recorded a fencing token")
+	}
+
+	a, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer a.Close()
+
+	b, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer b.Close()
+
+	testHarness.Process.Kill()
+	testHarness.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	last := harnessToken
+	for i := 0; i < 5; i++ {
+		m := a
+		if i%2 == 1 {
+			m = b
+		}
+
+		token, err := m.LockToken(ctx)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if token <= last {
+			t.Fatalf("token %d should be greater than the previous token %d", token, last)
+		}
+
+		current, ok := m.Token()
+		if !ok || current != token {
+			t.Fatalf("expected token %d for the current acquisition - got %d (%t)", token, current, ok)
+		}
+
+		recorded, err := CurrentToken(env.mutexConfig)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if recorded != token {
+			t.Fatalf("expected the recorded token to be %d - got %d", token, recorded)
+		}
+
+		m.Unlock()
+		last = token
+	}
+
+	err = a.TimedTryLock(time.Second)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	token, ok := a.Token()
+	if !ok || token != last+1 {
+		t.Fatalf("TimedTryLock should have yielded token %d - got %d (%t)", last+1, token, ok)
+	}
+
+	a.Unlock()
+
+	_, ok = a.Token()
+	if ok {
+		t.Fatal("there should be no token once the mutex is unlocked")
+	}
+}
+
+func TestMutex_LockTokenLease(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.FencingTokens = true
+	env.mutexConfig.LeaseTTL = time.Second
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	for i := uint64(1); i <= 3; i++ {
+		token, err := m.LockToken(context.Background())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if token != i {
+			t.Fatalf("expected token %d - got %d", i, token)
+		}
+
+		m.Unlock()
+	}
+}
+
+func TestMutex_LockTokenDisabled(t *testing.T) {
+	env := setupTestEnv(t)
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	_, err = m.LockToken(context.Background())
+	if _, ok := err.(*ConfigureError); !ok {
+		t.Fatalf("expected a *ConfigureError - got %v", err)
+	}
+
+	token, err := CurrentToken(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if token != 0 {
+		t.Fatalf("expected no token to be recorded - got %d", token)
+	}
+}
+
+func TestMutex_LockTokenCorrupt(t *testing.T) {
+	env := setupTestEnv(t)
+	env.mutexConfig.FencingTokens = true
+
+	err := ioutil.WriteFile(env.mutexConfig.Resource+tokenFileSuffix, []byte("garbage"), 0644)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	m, err := NewMutex(env.mutexConfig)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer m.Close()
+
+	start := time.Now()
+
+	// Retrying cannot fix the token file, so the lock attempt must
+	// fail right away instead of waiting for the timeout.
+	err = m.TimedTryLock(5 * time.Second)
+	lockErr, ok := err.(*LockError)
+	if !ok || !lockErr.FencingTokenCorrupt() {
+		t.Fatalf("expected a fencing token corrupt error - got %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Fatalf("lock attempt should have failed quickly - took %s", time.Since(start))
+	}
+
+	// The OS mutex must have been released.
+	held, err := isLockFileHeld(env.mutexConfig.Resource, flockMethod)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if held {
+		t.Fatal("the lock file should not be held after the lock attempt failed")
+	}
+}
@@ -0,0 +1,18 @@
+package ipcm
+
+import (
+	"fmt"
+)
+
+// CurrentToken returns the fencing token of the most recent acquisition
+// of the Mutex referenced by the MutexConfig.
+//
+// Fencing tokens are not currently supported on Windows. A *QueryError
+// is always returned.
+func CurrentToken(config MutexConfig) (uint64, error) {
+	return 0, &QueryError{
+		reason: fmt.Sprintf("%s fencing tokens are not supported on this operating system",
+			queryErrPrefix),
+		notSupported: true,
+	}
+}